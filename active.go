@@ -0,0 +1,71 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type activeRPCsEntry struct {
+	attrs   attribute.Set
+	current int64
+	max     int64
+}
+
+// activeRPCs keeps the number of in-flight rpcs per method and the highest value seen since the last collection.
+type activeRPCs struct {
+	mu      sync.Mutex
+	methods map[attribute.Distinct]*activeRPCsEntry
+}
+
+func newActiveRPCs() *activeRPCs {
+	return &activeRPCs{methods: make(map[attribute.Distinct]*activeRPCsEntry)}
+}
+
+func (a *activeRPCs) inc(attrs attribute.Set) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.methods[attrs.Equivalent()]
+	if !ok {
+		e = &activeRPCsEntry{attrs: attrs}
+		a.methods[attrs.Equivalent()] = e
+	}
+
+	e.current++
+	if e.current > e.max {
+		e.max = e.current
+	}
+}
+
+func (a *activeRPCs) dec(attrs attribute.Set) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e, ok := a.methods[attrs.Equivalent()]; ok {
+		e.current--
+	}
+}
+
+// observe reports the high-water mark of every method and starts a new window from the current value.
+// Methods without in-flight rpcs are dropped once reported to keep the map bounded to active methods.
+func (a *activeRPCs) observe(_ context.Context, o metric.Int64Observer) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for k, e := range a.methods {
+		o.Observe(e.max, metric.WithAttributeSet(e.attrs))
+
+		if e.current <= 0 {
+			delete(a.methods, k)
+
+			continue
+		}
+
+		e.max = e.current
+	}
+
+	return nil
+}
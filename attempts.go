@@ -0,0 +1,224 @@
+package grpcmetrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// transparentRetryKey is the rpc.grpc.transparent_retry attribute of client attempts.
+var transparentRetryKey = attribute.Key("rpc.grpc.transparent_retry")
+
+// attemptsPerCallBuckets cover retry policies which are limited to 5 attempts by gRPC.
+var attemptsPerCallBuckets = []float64{1, 2, 3, 4, 5}
+
+// callInfo is data used for recording metrics about a client call, made of one or more attempts.
+type callInfo struct {
+	// number of attempts started by the call, accessed atomically
+	attempts int64
+}
+
+type callInfoKey struct{}
+
+func setCallInfo(ctx context.Context, ci *callInfo) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, ci)
+}
+
+// getCallInfo returns the callInfo stored in the context, or nil if there isn't one.
+func getCallInfo(ctx context.Context) *callInfo {
+	ci, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return nil
+	}
+
+	return ci
+}
+
+// attempts are the instruments enabled by WithInstrumentAttempts on clients.
+type attempts struct {
+	mode            SemconvMode
+	attemptStarted  metric.Int64Counter
+	attemptDuration metric.Float64Histogram
+	callDuration    metric.Float64Histogram
+	attemptsPerCall metric.Int64Histogram
+}
+
+func newAttempts(meter metric.Meter, prefix string, mode SemconvMode) (*attempts, error) {
+	var err error
+
+	a := &attempts{mode: mode}
+
+	a.attemptStarted, err = meter.Int64Counter(prefix+".attempt.started", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	attemptDurationOptions := []metric.Float64HistogramOption{metric.WithUnit(durationUnit(mode))}
+	if mode != SemconvLegacy {
+		attemptDurationOptions = append(attemptDurationOptions, metric.WithExplicitBucketBoundaries(callDurationBuckets...))
+	}
+
+	a.attemptDuration, err = meter.Float64Histogram(prefix+".attempt.duration", attemptDurationOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// same as the call duration of SemconvStable, which is recorded per call instead of per attempt once interceptors are used.
+	a.callDuration, err = meter.Float64Histogram(prefix+".call.duration", metric.WithUnit("s"), metric.WithExplicitBucketBoundaries(callDurationBuckets...))
+	if err != nil {
+		return nil, err
+	}
+
+	a.attemptsPerCall, err = meter.Int64Histogram(prefix+".attempts_per_call", metric.WithUnit("1"), metric.WithExplicitBucketBoundaries(attemptsPerCallBuckets...))
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func transparentRetryAttributes(attrs attribute.Set, transparentRetry bool) attribute.Set {
+	return attribute.NewSet(append(attrs.ToSlice(), transparentRetryKey.Bool(transparentRetry))...)
+}
+
+// begin counts a started attempt.
+func (a *attempts) begin(ctx context.Context, ri *rpcInfo, rs *stats.Begin) {
+	ri.transparentRetry = rs.IsTransparentRetryAttempt
+
+	a.attemptStarted.Add(ctx, 1, metric.WithAttributeSet(transparentRetryAttributes(ri.methodAttrs, ri.transparentRetry)))
+}
+
+// end records the duration of a finished attempt.
+func (a *attempts) end(ctx context.Context, ri *rpcInfo, rs *stats.End, attrs attribute.Set) {
+	a.attemptDuration.Record(ctx, durationValue(a.mode, rs.EndTime.Sub(rs.BeginTime)), metric.WithAttributeSet(transparentRetryAttributes(attrs, ri.transparentRetry)))
+}
+
+// endCall records the duration and the number of attempts of a finished call.
+func (a *attempts) endCall(ctx context.Context, ci *callInfo, attrs attribute.Set, beginTime time.Time) {
+	a.callDuration.Record(ctx, time.Since(beginTime).Seconds(), metric.WithAttributeSet(attrs))
+	a.attemptsPerCall.Record(ctx, atomic.LoadInt64(&ci.attempts), metric.WithAttributeSet(attrs))
+}
+
+// callAttributes returns the attributes of a finished call, they follow SemconvStable as rpc.client.call.duration does.
+func (h *Handler) callAttributes(method string, isClientStream, isServerStream bool, err error) attribute.Set {
+	methodType := ""
+	if h.methodType {
+		methodType = getMethodType(isClientStream, isServerStream)
+	}
+
+	rpcStatus, errorType := h.classifyError(err)
+
+	return getStatusAttributes(SemconvStable, method, methodType, rpcStatus, errorType, nil)
+}
+
+// instrumentCall reports whether the call of method should be instrumented by the client interceptors.
+func (h *Handler) instrumentCall(method string) bool {
+	if h.attempts == nil {
+		return false
+	}
+
+	info := &stats.RPCTagInfo{FullMethodName: method}
+	for _, f := range h.filters {
+		if !f(info) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// UnaryClientInterceptor returns an interceptor recording rpc.client.call.duration and rpc.client.attempts_per_call
+// of each call, including all of its retry attempts. It requires WithInstrumentAttempts and must be used with
+// the same client Handler as stats handler, otherwise it does nothing.
+func (h *Handler) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !h.instrumentCall(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		ci := &callInfo{}
+		beginTime := time.Now()
+
+		err := invoker(setCallInfo(ctx, ci), method, req, reply, cc, opts...)
+
+		h.attempts.endCall(context.Background(), ci, h.callAttributes(method, false, false, err), beginTime)
+
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor recording rpc.client.call.duration and rpc.client.attempts_per_call
+// of each streaming call, including all of its retry attempts. A call ends once RecvMsg returns an error, the response
+// of a client streaming call is received or the context of the call is done. It requires WithInstrumentAttempts and must be used with the same client Handler
+// as stats handler, otherwise it does nothing.
+func (h *Handler) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !h.instrumentCall(method) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		ci := &callInfo{}
+		beginTime := time.Now()
+
+		var once sync.Once
+
+		end := func(err error) {
+			once.Do(func() {
+				h.attempts.endCall(context.Background(), ci, h.callAttributes(method, desc.ClientStreams, desc.ServerStreams, err), beginTime)
+			})
+		}
+
+		s, err := streamer(setCallInfo(ctx, ci), desc, cc, method, opts...)
+		if err != nil {
+			end(err)
+
+			return nil, err
+		}
+
+		// callers can abandon a stream without draining it, the call ends with their context then.
+		stop := context.AfterFunc(ctx, func() {
+			end(status.FromContextError(ctx.Err()).Err())
+		})
+
+		return &callStream{ClientStream: s, serverStreams: desc.ServerStreams, end: end, stop: stop}, nil
+	}
+}
+
+// callStream calls end once the call is finished.
+type callStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	end           func(error)
+	// stops ending the call with its context
+	stop func() bool
+}
+
+func (s *callStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+
+	switch {
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.serverStreams:
+		// the only response of the call is received.
+		s.finish(nil)
+	}
+
+	return err
+}
+
+func (s *callStream) finish(err error) {
+	s.stop()
+	s.end(err)
+}
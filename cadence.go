@@ -0,0 +1,105 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/stats"
+)
+
+// streamCadence are the instruments enabled by WithInstrumentStreamCadence.
+type streamCadence struct {
+	mode SemconvMode
+
+	interSendTime    metric.Float64Histogram
+	interArrivalTime metric.Float64Histogram
+	maxIdleTime      metric.Float64Histogram
+}
+
+func newStreamCadence(meter metric.Meter, prefix string, mode SemconvMode) (*streamCadence, error) {
+	var err error
+
+	c := streamCadence{mode: mode}
+	unit := durationUnit(mode)
+
+	c.interSendTime, err = meter.Float64Histogram(prefix+".stream.inter_send_time", metric.WithUnit(unit))
+	if err != nil {
+		return nil, err
+	}
+
+	c.interArrivalTime, err = meter.Float64Histogram(prefix+".stream.inter_arrival_time", metric.WithUnit(unit))
+	if err != nil {
+		return nil, err
+	}
+
+	c.maxIdleTime, err = meter.Float64Histogram(prefix+".stream.max_idle_time", metric.WithUnit(unit))
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// begin marks the rpc as a stream if either side is streaming, unary rpcs are not tracked.
+func (c *streamCadence) begin(ri *rpcInfo, rs *stats.Begin) {
+	if !rs.IsClientStream && !rs.IsServerStream {
+		return
+	}
+
+	atomic.StoreInt64(&ri.streamLastMessageTime, rs.BeginTime.UnixNano())
+	atomic.StoreInt32(&ri.isStream, 1)
+}
+
+// message records the gap since the previous message in the same direction and tracks the idle time of the stream.
+func (c *streamCadence) message(ctx context.Context, ri *rpcInfo, t time.Time, sent bool) {
+	if atomic.LoadInt32(&ri.isStream) == 0 {
+		return
+	}
+
+	last, gaps := &ri.streamLastRecvTime, c.interArrivalTime
+	if sent {
+		last, gaps = &ri.streamLastSentTime, c.interSendTime
+	}
+
+	now := t.UnixNano()
+
+	if prev := atomic.SwapInt64(last, now); prev != 0 && now >= prev {
+		gaps.Record(ctx, durationValue(c.mode, time.Duration(now-prev)), metric.WithAttributeSet(ri.methodAttrs))
+	}
+
+	ri.updateStreamIdle(now)
+}
+
+// record the longest idle time of the stream, including the time from the last message to the end.
+func (c *streamCadence) record(ctx context.Context, ri *rpcInfo, end *stats.End, attrs attribute.Set) {
+	if atomic.LoadInt32(&ri.isStream) == 0 {
+		return
+	}
+
+	ri.updateStreamIdle(end.EndTime.UnixNano())
+
+	c.maxIdleTime.Record(ctx, durationValue(c.mode, time.Duration(atomic.LoadInt64(&ri.streamMaxIdle))), metric.WithAttributeSet(attrs))
+}
+
+// updateStreamIdle moves the last activity of the stream to now and updates the longest idle time.
+func (ri *rpcInfo) updateStreamIdle(now int64) {
+	prev := atomic.SwapInt64(&ri.streamLastMessageTime, now)
+	if now < prev {
+		// messages in both directions can race, keep the latest as the last activity.
+		atomic.CompareAndSwapInt64(&ri.streamLastMessageTime, now, prev)
+
+		return
+	}
+
+	idle := now - prev
+
+	for {
+		current := atomic.LoadInt64(&ri.streamMaxIdle)
+		if idle <= current || atomic.CompareAndSwapInt64(&ri.streamMaxIdle, current, idle) {
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// encodingKey is the grpc-encoding of the messages, "identity" when they are not compressed.
+const encodingKey = attribute.Key("rpc.grpc.encoding")
+
+// wireSizes are the instruments enabled by WithInstrumentWireSizes.
+type wireSizes struct {
+	requestWireSize          metric.Int64Histogram
+	responseWireSize         metric.Int64Histogram
+	requestCompressionRatio  metric.Float64Histogram
+	responseCompressionRatio metric.Float64Histogram
+}
+
+func newWireSizes(meter metric.Meter, prefix string) (*wireSizes, error) {
+	var (
+		w   wireSizes
+		err error
+	)
+
+	w.requestWireSize, err = meter.Int64Histogram(prefix+".request.wire_size", metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	w.responseWireSize, err = meter.Int64Histogram(prefix+".response.wire_size", metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	w.requestCompressionRatio, err = meter.Float64Histogram(prefix+".request.compression_ratio", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	w.responseCompressionRatio, err = meter.Float64Histogram(prefix+".response.compression_ratio", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func encodingAttributes(attrs []attribute.KeyValue, encoding string) attribute.Set {
+	if encoding == "" {
+		encoding = "identity"
+	}
+
+	return attribute.NewSet(append(attrs, encodingKey.String(encoding))...)
+}
+
+// record the wire bytes of the rpc and the ratio of uncompressed to compressed bytes for both directions.
+func (w *wireSizes) record(ctx context.Context, ri *rpcInfo, isClient bool, attrs attribute.Set) {
+	sentWireSize, recvWireSize := w.responseWireSize, w.requestWireSize
+	sentRatio, recvRatio := w.responseCompressionRatio, w.requestCompressionRatio
+
+	if isClient {
+		sentWireSize, recvWireSize = recvWireSize, sentWireSize
+		sentRatio, recvRatio = recvRatio, sentRatio
+	}
+
+	ri.mu.Lock()
+	sentEncoding, recvEncoding := ri.sentEncoding, ri.recvEncoding
+	ri.mu.Unlock()
+
+	sentAttrs := encodingAttributes(attrs.ToSlice(), sentEncoding)
+	recvAttrs := encodingAttributes(attrs.ToSlice(), recvEncoding)
+
+	sentWireSize.Record(ctx, atomic.LoadInt64(&ri.sentWireBytes), metric.WithAttributeSet(sentAttrs))
+	recvWireSize.Record(ctx, atomic.LoadInt64(&ri.recvWireBytes), metric.WithAttributeSet(recvAttrs))
+
+	if compressed := atomic.LoadInt64(&ri.sentCompressedBytes); compressed > 0 {
+		sentRatio.Record(ctx, float64(atomic.LoadInt64(&ri.sentBytes))/float64(compressed), metric.WithAttributeSet(sentAttrs))
+	}
+
+	if compressed := atomic.LoadInt64(&ri.recvCompressedBytes); compressed > 0 {
+		recvRatio.Record(ctx, float64(atomic.LoadInt64(&ri.recvBytes))/float64(compressed), metric.WithAttributeSet(recvAttrs))
+	}
+}
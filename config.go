@@ -1,6 +1,15 @@
 package grpcmetrics
 
-import "go.opentelemetry.io/otel/metric"
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
 
 // Option applies an option value when creating a Handler.
 type Option interface {
@@ -14,10 +23,95 @@ func (f optionFunc) apply(c *config) {
 }
 
 type config struct {
-	meterProvider       metric.MeterProvider
-	instrumentationName string
-	instrumentSizes     bool
-	instrumentLatency   bool
+	semconvMode             SemconvMode
+	meterProvider           metric.MeterProvider
+	instrumentationName     string
+	instrumentSizes         bool
+	instrumentLatency       bool
+	instrumentLatencyPhases bool
+	instrumentStreamCadence bool
+	interimReporting        bool
+	interimInterval         time.Duration
+	stuckRPCThreshold       time.Duration
+	stuckRPCsFunc           StuckRPCsFunc
+	perMessageSizes         bool
+	instrumentWireSizes     bool
+	messagesPerRPCMode      MessagesPerRPCMode
+
+	instrumentActiveRPCsMax bool
+	instrumentAttempts      bool
+	retryAttempts           bool
+	instrumentDeadlines     bool
+
+	instrumentConnectionDuration bool
+
+	attributesFunc      AttributesFunc
+	methodTypeAttribute bool
+	errorOrigin         bool
+
+	metadataKeys      []string
+	metadataMaxLength int
+	metadataFallback  string
+
+	instrumentMetadataSizes bool
+	metadataSizeThreshold   int
+
+	networkAttributes        bool
+	networkPeerIPv4PrefixLen int
+	networkPeerIPv6PrefixLen int
+
+	callerIdentityFunc  CallerIdentityFunc
+	maxCallerIdentities int
+	tlsAttributes       bool
+
+	zoneResolver ZoneResolver
+
+	errorClassifier ErrorClassifier
+	maxErrorTypes   int
+
+	filters []Filter
+}
+
+func newConfig(options []Option) config {
+	c := config{}
+
+	for _, o := range options {
+		o.apply(&c)
+	}
+
+	if c.meterProvider == nil {
+		c.meterProvider = otel.GetMeterProvider()
+	}
+
+	if c.instrumentationName == "" {
+		c.instrumentationName = DefaultInstrumentationName
+	}
+
+	return c
+}
+
+// SemconvMode selects the semantic conventions version of the recorded metrics.
+type SemconvMode int
+
+const (
+	// SemconvLegacy records rpc.{server|client}.duration in milliseconds with rpc.grpc.status attribute.
+	SemconvLegacy SemconvMode = iota
+	// SemconvStable records rpc.{server|client}.call.duration in seconds with
+	// server.address, server.port and error.type attributes as defined by the current semantic conventions.
+	// server.address and server.port are only recorded on servers, clients only know the resolved backend address
+	// which would create a series per backend instead of the logical target.
+	SemconvStable
+	// SemconvDuplicate records both durations to migrate without a flag day, rpc.{server|client}.call.duration
+	// gets the attributes of SemconvStable while every other instrument keeps the attributes of SemconvLegacy.
+	SemconvDuplicate
+)
+
+// WithSemconvMode returns an Option to choose the semantic conventions of the metrics, defaults to SemconvLegacy.
+// rpc.{server|client}.call.duration uses the recommended bucket boundaries unless a view overrides them.
+func WithSemconvMode(mode SemconvMode) Option {
+	return optionFunc(func(c *config) {
+		c.semconvMode = mode
+	})
 }
 
 // WithInstrumentationName returns an Option to set custom name for metrics scope.
@@ -42,10 +136,291 @@ func WithInstrumentSizes(instrumentSizes bool) Option {
 	})
 }
 
-// WithInstrumentLatency enable instrument for rpc.{server|client}.duration.
+// WithPerMessageSizes makes rpc.{server|client}.request.size and rpc.{server|client}.response.size
+// record the size of each message as defined by the semantic conventions, instead of one total per rpc.
+// The per rpc totals are recorded as rpc.{server|client}.request.total_size and rpc.{server|client}.response.total_size.
+// Messages are recorded before the rpc ends so they don't have the status attributes.
+// It has no effect unless WithInstrumentSizes is enabled.
+func WithPerMessageSizes(perMessageSizes bool) Option {
+	return optionFunc(func(c *config) {
+		c.perMessageSizes = perMessageSizes
+	})
+}
+
+// MessagesPerRPCMode selects the instruments of rpc.{server|client}.requests_per_rpc and rpc.{server|client}.responses_per_rpc.
+type MessagesPerRPCMode int
+
+const (
+	// MessagesPerRPCCounter records the total number of messages as counters.
+	MessagesPerRPCCounter MessagesPerRPCMode = iota
+	// MessagesPerRPCHistogram records the number of messages of each rpc as histograms defined by the semantic conventions.
+	MessagesPerRPCHistogram
+	// MessagesPerRPCBoth records the counters and the histograms with a ".histogram" suffix.
+	MessagesPerRPCBoth
+)
+
+// WithMessagesPerRPCMode returns an Option to choose how messages per rpc are recorded, defaults to MessagesPerRPCCounter.
+// Histograms show the distribution of messages per stream which is quite costly.
+// They use exponential bucket boundaries suited for message counts unless a view overrides them.
+func WithMessagesPerRPCMode(mode MessagesPerRPCMode) Option {
+	return optionFunc(func(c *config) {
+		c.messagesPerRPCMode = mode
+	})
+}
+
+// WithInstrumentConnectionDuration enable instrument for rpc.{server|client}.connection.duration, the lifetime of connections.
+// Unit follows the duration of SemconvMode.
+// This is a histogram which is quite costly.
+func WithInstrumentConnectionDuration(instrumentConnectionDuration bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentConnectionDuration = instrumentConnectionDuration
+	})
+}
+
+// WithInstrumentLatencyPhases enable instrument for rpc.client.time_to_first_header, rpc.{server|client}.time_to_first_response
+// and rpc.{server|client}.trailer_latency which break the rpc duration down to where the time goes.
+// Time to first response is measured until the first response message is received by the client or sent by the server,
+// trailer latency is measured from the last message to the end of the rpc. Units follow the duration of SemconvMode.
+// These are histograms which are quite costly.
+func WithInstrumentLatencyPhases(instrumentLatencyPhases bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentLatencyPhases = instrumentLatencyPhases
+	})
+}
+
+// WithInstrumentStreamCadence enable instrument for rpc.{server|client}.stream.inter_send_time and rpc.{server|client}.stream.inter_arrival_time
+// of every message after the first one, and rpc.{server|client}.stream.max_idle_time which is the longest time without a message per rpc.
+// Only client, server and bidi streaming rpcs are instrumented. Units follow the duration of SemconvMode.
+// These are histograms which are quite costly.
+func WithInstrumentStreamCadence(instrumentStreamCadence bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentStreamCadence = instrumentStreamCadence
+	})
+}
+
+// WithInterimReporting enable instrument for rpc.{server|client}.{request|response}.messages and rpc.{server|client}.{request|response}.bytes
+// counters which include the messages of in-flight rpcs, so the throughput of long-lived streams is visible before they end.
+// Totals are computed on each collection when interval is 0, otherwise they are refreshed every interval in the background
+// until Handler.Close is called.
+func WithInterimReporting(interval time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.interimReporting = true
+		c.interimInterval = interval
+	})
+}
+
+// WithStuckRPCThreshold enable tracking in-flight rpcs and instrument for rpc.{server|client}.stuck_rpcs,
+// the number of rpcs per method in-flight for longer than threshold. In-flight rpcs can be listed with Handler.InFlight.
+func WithStuckRPCThreshold(threshold time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.stuckRPCThreshold = threshold
+	})
+}
+
+// WithStuckRPCsFunc sets a function called on each collection with the stuck rpcs, it's only used with WithStuckRPCThreshold.
+func WithStuckRPCsFunc(fn StuckRPCsFunc) Option {
+	return optionFunc(func(c *config) {
+		c.stuckRPCsFunc = fn
+	})
+}
+
+// WithInstrumentWireSizes enable instrument for rpc.{server|client}.{request|response}.wire_size
+// and rpc.{server|client}.{request|response}.compression_ratio with the rpc.grpc.encoding attribute.
+// Wire sizes include compression and message framing, compression ratio is uncompressed divided by compressed bytes.
+// These are histograms which are quite costly.
+func WithInstrumentWireSizes(instrumentWireSizes bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentWireSizes = instrumentWireSizes
+	})
+}
+
+// WithInstrumentMetadataSizes enable instrument for rpc.{server|client}.metadata.keys, rpc.{server|client}.metadata.size
+// and rpc.{server|client}.metadata.wire_size of every header and trailer with the rpc.grpc.metadata.kind (header or trailer)
+// and rpc.grpc.metadata.direction (sent or received) attributes. Size is computed the same way as the HTTP/2 header list size,
+// wire size is only recorded for received metadata where gRPC provides it.
+// These are histograms which are quite costly.
+func WithInstrumentMetadataSizes(instrumentMetadataSizes bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentMetadataSizes = instrumentMetadataSizes
+	})
+}
+
+// WithMetadataSizeThreshold enable counting rpcs with a header or trailer larger than size bytes in rpc.{server|client}.metadata.oversized.
+// Use it to get warned before metadata hits the header list size limits of servers and proxies.
+func WithMetadataSizeThreshold(size int) Option {
+	return optionFunc(func(c *config) {
+		c.metadataSizeThreshold = size
+	})
+}
+
+// WithInstrumentLatency enable instrument for rpc.{server|client}.duration or rpc.{server|client}.call.duration depending on SemconvMode.
 // This is a histogram which is quite costly.
 func WithInstrumentLatency(instrumentLatency bool) Option {
 	return optionFunc(func(c *config) {
 		c.instrumentLatency = instrumentLatency
 	})
 }
+
+// WithInstrumentActiveRPCsMax enable instrument for rpc.{server|client}.active_rpcs.max.
+// It is an async gauge reporting the highest number of in-flight rpcs per method since the previous collection.
+func WithInstrumentActiveRPCsMax(instrumentActiveRPCsMax bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentActiveRPCsMax = instrumentActiveRPCsMax
+	})
+}
+
+// WithInstrumentAttempts enable instrument for rpc.client.attempt.started and rpc.client.attempt.duration of each attempt
+// with the rpc.grpc.transparent_retry attribute. Used with Handler.UnaryClientInterceptor and Handler.StreamClientInterceptor,
+// rpc.client.call.duration and rpc.client.attempts_per_call are recorded once per call including its retries instead of per attempt.
+// Units of attempt duration follow the duration of SemconvMode. It's ignored on servers.
+// These are histograms which are quite costly.
+func WithInstrumentAttempts(instrumentAttempts bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentAttempts = instrumentAttempts
+	})
+}
+
+// WithRetryAttempts returns an Option to record the rpc.grpc.retry_attempt attribute (0, 1, 2 or 3+) on every instrument of servers,
+// taken from the grpc-previous-rpc-attempts header sent by clients retrying an rpc with a retry policy,
+// and enable instrument for rpc.server.retried_requests, the number of rpcs which are a retry. It's ignored on clients.
+func WithRetryAttempts(retryAttempts bool) Option {
+	return optionFunc(func(c *config) {
+		c.retryAttempts = retryAttempts
+	})
+}
+
+// WithInstrumentDeadlines enable instrument for rpc.{server|client}.deadline.remaining, the time left until the deadline when
+// an rpc begins, rpc.{server|client}.deadline.consumed, the fraction of the deadline used by the rpc when it ends,
+// and rpc.{server|client}.deadline.missing, the number of rpcs without a deadline. The deadline is taken from the rpc context
+// which servers derive from the grpc-timeout header. Unit of the remaining deadline follows the duration of SemconvMode.
+// These are histograms which are quite costly.
+func WithInstrumentDeadlines(instrumentDeadlines bool) Option {
+	return optionFunc(func(c *config) {
+		c.instrumentDeadlines = instrumentDeadlines
+	})
+}
+
+// AttributesFunc returns extra attributes for a finished rpc.
+// ctx is the rpc context, s is the final status of the rpc and end is the stats.End event.
+type AttributesFunc func(ctx context.Context, info *stats.RPCTagInfo, s *status.Status, end *stats.End) []attribute.KeyValue
+
+// WithAttributesFunc returns an Option to add custom attributes to the instruments recorded when an rpc ends.
+// Attributes with the same key as the standard rpc.* attributes are ignored.
+func WithAttributesFunc(fn AttributesFunc) Option {
+	return optionFunc(func(c *config) {
+		c.attributesFunc = fn
+	})
+}
+
+// WithMethodTypeAttribute returns an Option to record the rpc.grpc.method_type attribute on every instrument,
+// one of unary, client_streaming, server_streaming or bidi_streaming.
+// The type is taken from stats.Begin, servers using RegisterServices know it from the registered services
+// which also covers the sizes of received headers handled before stats.Begin.
+func WithMethodTypeAttribute(methodType bool) Option {
+	return optionFunc(func(c *config) {
+		c.methodTypeAttribute = methodType
+	})
+}
+
+// WithErrorOrigin returns an Option to record the error.origin attribute of rpcs ending with DeadlineExceeded or Canceled.
+// On clients it's local when the client's own context is done and remote when the status is sent by the server.
+// On servers it's remote when the deadline of the caller is over or the caller canceled the rpc before its status
+// was sent, and propagated when the handler returned it on its own, e.g. from a downstream call which timed out.
+func WithErrorOrigin(errorOrigin bool) Option {
+	return optionFunc(func(c *config) {
+		c.errorOrigin = errorOrigin
+	})
+}
+
+// WithErrorClassifier returns an Option to classify the errors of failed rpcs with fn, e.g. DefaultErrorClassifier.
+// The status returned by fn is recorded as the status of the rpc and its category as the error.type attribute
+// of the instruments recorded when rpcs end, also in SemconvLegacy mode.
+// Only the first maxErrorTypes distinct categories are recorded, others are recorded as "_OTHER". maxErrorTypes <= 0 allows 20 categories.
+func WithErrorClassifier(fn ErrorClassifier, maxErrorTypes int) Option {
+	return optionFunc(func(c *config) {
+		c.errorClassifier = fn
+		c.maxErrorTypes = maxErrorTypes
+	})
+}
+
+// WithMetadataAttributes returns an Option to record the values of the given request metadata keys
+// as rpc.grpc.request.metadata.<key> attributes. Metadata is read from the incoming context on the server side
+// and from the outgoing context on the client side.
+// Each key adds a new dimension to the metrics so only use keys with a small set of values.
+func WithMetadataAttributes(keys ...string) Option {
+	return optionFunc(func(c *config) {
+		c.metadataKeys = append(c.metadataKeys, keys...)
+	})
+}
+
+// WithMetadataMaxLength returns an Option to truncate metadata attribute values to maxLength bytes.
+func WithMetadataMaxLength(maxLength int) Option {
+	return optionFunc(func(c *config) {
+		c.metadataMaxLength = maxLength
+	})
+}
+
+// WithMetadataFallback returns an Option to record value for metadata keys which are missing from the request.
+// By default missing keys are not recorded.
+func WithMetadataFallback(value string) Option {
+	return optionFunc(func(c *config) {
+		c.metadataFallback = value
+	})
+}
+
+// WithNetworkAttributes returns an Option to record the network.transport, network.type, server.port and network.local.address
+// attributes of the connection an rpc is sent on when it ends, and on the connection metrics.
+// They tell apart the traffic of multiple listeners of a server, e.g. a TCP port and a unix socket.
+func WithNetworkAttributes(networkAttributes bool) Option {
+	return optionFunc(func(c *config) {
+		c.networkAttributes = networkAttributes
+	})
+}
+
+// WithNetworkPeerAddress returns an Option to record network.peer.address truncated to the subnet of the given prefix lengths,
+// e.g. 24 and 64. Peer addresses of a family with a 0 prefix length are not recorded, prefix lengths longer than the address
+// of the family record the whole address. It's only used with WithNetworkAttributes.
+// Each subnet adds a new dimension to the metrics so only use prefix lengths with a small set of peer subnets.
+func WithNetworkPeerAddress(ipv4PrefixLen, ipv6PrefixLen int) Option {
+	return optionFunc(func(c *config) {
+		c.networkPeerIPv4PrefixLen = ipv4PrefixLen
+		c.networkPeerIPv6PrefixLen = ipv6PrefixLen
+	})
+}
+
+// WithCallerIdentity returns an Option to record the identity of callers connected over TLS returned by fn,
+// e.g. SPIFFEIdentity, as the rpc.caller.identity attribute on every instrument of servers.
+// Only the first maxIdentities distinct identities are recorded, others are recorded as "_OTHER". maxIdentities <= 0 allows 100 identities.
+// It's ignored on clients.
+func WithCallerIdentity(fn CallerIdentityFunc, maxIdentities int) Option {
+	return optionFunc(func(c *config) {
+		c.callerIdentityFunc = fn
+		c.maxCallerIdentities = maxIdentities
+	})
+}
+
+// WithTLSAttributes returns an Option to record the tls.protocol.version and tls.cipher attributes
+// of callers connected over TLS on every instrument of servers. It's ignored on clients.
+func WithTLSAttributes(tlsAttributes bool) Option {
+	return optionFunc(func(c *config) {
+		c.tlsAttributes = tlsAttributes
+	})
+}
+
+// WithZoneResolver returns an Option to record the local.zone and peer.zone attributes resolved by r, e.g. a CIDRZoneResolver,
+// on the instruments recorded when an rpc ends, and to enable instrument for rpc.{server|client}.zone.sent_bytes
+// and rpc.{server|client}.zone.received_bytes, the message bytes sent and received per pair of zones.
+// Addresses which are not resolved are recorded as "unknown".
+func WithZoneResolver(r ZoneResolver) Option {
+	return optionFunc(func(c *config) {
+		c.zoneResolver = r
+	})
+}
+
+// WithFilter returns an Option to only instrument rpcs accepted by f.
+// When used multiple times an rpc is only instrumented if it is accepted by every Filter.
+func WithFilter(f Filter) Option {
+	return optionFunc(func(c *config) {
+		c.filters = append(c.filters, f)
+	})
+}
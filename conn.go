@@ -0,0 +1,125 @@
+package grpcmetrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/stats"
+)
+
+// connInfo is data used for recording metrics about a single connection.
+type connInfo struct {
+	beginTime time.Time
+	attrs     attribute.Set
+	// network attributes of the connection, nil unless WithNetworkAttributes is used
+	netAttrs []attribute.KeyValue
+}
+
+type connInfoKey struct{}
+
+func setConnInfo(ctx context.Context, ci *connInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, ci)
+}
+
+// getConnInfo returns the connInfo stored in the context, or nil if there isn't one.
+func getConnInfo(ctx context.Context) *connInfo {
+	ci, ok := ctx.Value(connInfoKey{}).(*connInfo)
+	if !ok {
+		return nil
+	}
+
+	return ci
+}
+
+// addrFamily returns the socket family of addr as defined by net.sock.family, or empty string if it is unknown.
+func addrFamily(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return ipFamily(a.IP)
+	case *net.UDPAddr:
+		return ipFamily(a.IP)
+	case *net.IPAddr:
+		return ipFamily(a.IP)
+	case *net.UnixAddr:
+		return "unix"
+	case nil:
+		return ""
+	}
+
+	if addr.Network() == "unix" {
+		return "unix"
+	}
+
+	return ""
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "inet"
+	}
+
+	if ip.To16() != nil {
+		return "inet6"
+	}
+
+	return ""
+}
+
+func getConnAttributes(info *stats.ConnTagInfo, extra []attribute.KeyValue) attribute.Set {
+	attr := make([]attribute.KeyValue, 0, 2+len(extra)) //nolint:gomnd
+	attr = append(attr, extra...)
+
+	if family := addrFamily(info.LocalAddr); family != "" {
+		attr = append(attr, attribute.Key("net.sock.host.family").String(family))
+	}
+
+	if family := addrFamily(info.RemoteAddr); family != "" {
+		attr = append(attr, attribute.Key("net.sock.peer.family").String(family))
+	}
+
+	return attribute.NewSet(attr...)
+}
+
+// TagConn attaches connection info to the context used for the connection stats.
+func (h *Handler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	ci := &connInfo{beginTime: time.Now()}
+
+	if h.network != nil {
+		ci.netAttrs = h.network.get(info.LocalAddr, info.RemoteAddr)
+	}
+
+	ci.attrs = getConnAttributes(info, ci.netAttrs)
+
+	return setConnInfo(ctx, ci)
+}
+
+// HandleConn implements per-connection stats instrumentation.
+func (h *Handler) HandleConn(ctx context.Context, cs stats.ConnStats) {
+	ci := getConnInfo(ctx)
+	if ci == nil {
+		return
+	}
+
+	// use a new context since original ctx could be canceled during this state.
+	subCtx := context.Background()
+
+	switch cs.(type) {
+	case *stats.ConnBegin:
+		h.connOpened.Add(subCtx, 1, metric.WithAttributeSet(ci.attrs))
+		h.connActive.Add(subCtx, 1, metric.WithAttributeSet(ci.attrs))
+	case *stats.ConnEnd:
+		h.connClosed.Add(subCtx, 1, metric.WithAttributeSet(ci.attrs))
+		h.connActive.Add(subCtx, -1, metric.WithAttributeSet(ci.attrs))
+
+		if h.connDuration != nil {
+			h.connDuration.Record(subCtx, durationValue(h.semconvMode, time.Since(ci.beginTime)), metric.WithAttributeSet(ci.attrs))
+		}
+	default:
+		otel.Handle(fmt.Errorf("received unhandled conn stats with type (%T) and data: %v", cs, cs))
+	}
+}
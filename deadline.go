@@ -0,0 +1,85 @@
+package grpcmetrics
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/stats"
+)
+
+// deadlineRemainingBuckets are the bucket boundaries in seconds of the remaining deadline, from fast lookups to long streams.
+var deadlineRemainingBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 3600}
+
+// deadlineConsumedBuckets are the bucket boundaries of the consumed fraction of the deadline, rpcs above 1 outlived it.
+var deadlineConsumedBuckets = []float64{0.05, 0.1, 0.25, 0.5, 0.75, 0.9, 1, 1.25}
+
+// deadlines are the instruments enabled by WithInstrumentDeadlines.
+type deadlines struct {
+	mode      SemconvMode
+	remaining metric.Float64Histogram
+	consumed  metric.Float64Histogram
+	missing   metric.Int64Counter
+}
+
+func newDeadlines(meter metric.Meter, prefix string, mode SemconvMode) (*deadlines, error) {
+	var err error
+
+	d := &deadlines{mode: mode}
+
+	remainingBuckets := deadlineRemainingBuckets
+	if mode == SemconvLegacy {
+		remainingBuckets = make([]float64, len(deadlineRemainingBuckets))
+		for i, b := range deadlineRemainingBuckets {
+			remainingBuckets[i] = b * 1000 //nolint:gomnd
+		}
+	}
+
+	d.remaining, err = meter.Float64Histogram(prefix+".deadline.remaining", metric.WithUnit(durationUnit(mode)), metric.WithExplicitBucketBoundaries(remainingBuckets...))
+	if err != nil {
+		return nil, err
+	}
+
+	d.consumed, err = meter.Float64Histogram(prefix+".deadline.consumed", metric.WithUnit("1"), metric.WithExplicitBucketBoundaries(deadlineConsumedBuckets...))
+	if err != nil {
+		return nil, err
+	}
+
+	d.missing, err = meter.Int64Counter(prefix+".deadline.missing", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// begin records the remaining deadline of the rpc when it begins, or counts it when it has no deadline.
+func (d *deadlines) begin(ctx context.Context, ri *rpcInfo, beginTime time.Time) {
+	if ri.deadline.IsZero() {
+		d.missing.Add(ctx, 1, metric.WithAttributeSet(ri.methodAttrs))
+
+		return
+	}
+
+	remaining := ri.deadline.Sub(beginTime)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	d.remaining.Record(ctx, durationValue(d.mode, remaining), metric.WithAttributeSet(ri.methodAttrs))
+}
+
+// end records the fraction of the deadline consumed by the rpc.
+func (d *deadlines) end(ctx context.Context, ri *rpcInfo, rs *stats.End, attrs attribute.Set) {
+	if ri.deadline.IsZero() {
+		return
+	}
+
+	budget := ri.deadline.Sub(rs.BeginTime)
+	if budget <= 0 {
+		return
+	}
+
+	d.consumed.Record(ctx, float64(rs.EndTime.Sub(rs.BeginTime))/float64(budget), metric.WithAttributeSet(attrs))
+}
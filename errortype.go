@@ -0,0 +1,109 @@
+package grpcmetrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultMaxErrorTypes is the number of distinct error types recorded when WithErrorClassifier is given no limit.
+const defaultMaxErrorTypes = 20
+
+// ErrorClassifier returns the status and the error.type category of the error a failed rpc ended with.
+// Errors classified as OK are recorded as successful rpcs without error.type.
+// A nil status falls back to the default mapping of the error and an empty category to the name of the status code.
+type ErrorClassifier func(err error) (*status.Status, string)
+
+// DefaultErrorClassifier is an ErrorClassifier keeping the status of gRPC errors and mapping context errors
+// to DeadlineExceeded and Canceled, any other error is Internal. The category is the name of the status code.
+func DefaultErrorClassifier(err error) (*status.Status, string) {
+	s := classifiedStatus(err)
+
+	return s, s.Code().String()
+}
+
+// classifiedStatus returns the status of err like getRPCStatus, but maps context errors to their gRPC status.
+// It's only used with WithErrorClassifier to not change the status of existing series.
+func classifiedStatus(err error) *status.Status {
+	if _, ok := status.FromError(err); !ok && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)) {
+		return status.FromContextError(err)
+	}
+
+	return getRPCStatus(err)
+}
+
+// errorTypes classifies the errors of finished rpcs with WithErrorClassifier.
+type errorTypes struct {
+	classifier ErrorClassifier
+	maxTypes   int
+
+	mu sync.Mutex
+	// distinct error types recorded so far
+	types map[string]struct{}
+}
+
+func newErrorTypes(c config) *errorTypes {
+	if c.errorClassifier == nil {
+		return nil
+	}
+
+	e := &errorTypes{
+		classifier: c.errorClassifier,
+		maxTypes:   c.maxErrorTypes,
+		types:      make(map[string]struct{}),
+	}
+
+	if e.maxTypes <= 0 {
+		e.maxTypes = defaultMaxErrorTypes
+	}
+
+	return e
+}
+
+// classify returns the status and the error type of err, the error type is one of the first maxTypes distinct
+// categories returned by the classifier, otherwise "_OTHER".
+func (e *errorTypes) classify(err error) (*status.Status, string) {
+	if err == nil {
+		return grpcStatusOK, ""
+	}
+
+	s, errorType := e.classifier(err)
+	if s == nil {
+		s = classifiedStatus(err)
+	}
+
+	if s.Code() == codes.OK {
+		return s, ""
+	}
+
+	if errorType == "" {
+		errorType = s.Code().String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.types[errorType]; ok {
+		return s, errorType
+	}
+
+	if len(e.types) >= e.maxTypes {
+		return s, otherValue
+	}
+
+	e.types[errorType] = struct{}{}
+
+	return s, errorType
+}
+
+// classifyError returns the status and the error type of err, the error type is empty unless WithErrorClassifier is used.
+func (h *Handler) classifyError(err error) (*status.Status, string) {
+	if h.errorTypes == nil {
+		return getRPCStatus(err), ""
+	}
+
+	return h.errorTypes.classify(err)
+}
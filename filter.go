@@ -0,0 +1,53 @@
+package grpcmetrics
+
+import (
+	"path"
+	"regexp"
+
+	"google.golang.org/grpc/stats"
+)
+
+// Filter reports whether an rpc should be instrumented.
+// It is evaluated in TagRPC so it must be cheap and safe for concurrent use.
+type Filter func(info *stats.RPCTagInfo) bool
+
+// matchGlobs reports whether fullMethodName matches any of the path.Match patterns.
+func matchGlobs(patterns []string, fullMethodName string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, fullMethodName); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowMethods returns a Filter which only instruments rpcs with a full method name matching one of the glob patterns,
+// e.g. "/product.Products/*". Patterns use the path.Match syntax and malformed patterns never match.
+func AllowMethods(patterns ...string) Filter {
+	return func(info *stats.RPCTagInfo) bool {
+		return matchGlobs(patterns, info.FullMethodName)
+	}
+}
+
+// DenyMethods returns a Filter which ignores rpcs with a full method name matching one of the glob patterns,
+// e.g. "/grpc.health.v1.Health/*". Patterns use the path.Match syntax and malformed patterns never match.
+func DenyMethods(patterns ...string) Filter {
+	return func(info *stats.RPCTagInfo) bool {
+		return !matchGlobs(patterns, info.FullMethodName)
+	}
+}
+
+// AllowMethodsRegexp returns a Filter which only instruments rpcs with a full method name matching re.
+func AllowMethodsRegexp(re *regexp.Regexp) Filter {
+	return func(info *stats.RPCTagInfo) bool {
+		return re.MatchString(info.FullMethodName)
+	}
+}
+
+// DenyMethodsRegexp returns a Filter which ignores rpcs with a full method name matching re.
+func DenyMethodsRegexp(re *regexp.Regexp) Filter {
+	return func(info *stats.RPCTagInfo) bool {
+		return !re.MatchString(info.FullMethodName)
+	}
+}
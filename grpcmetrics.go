@@ -4,15 +4,20 @@ package grpcmetrics
 import (
 	"context"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
-	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 )
@@ -22,11 +27,36 @@ const (
 	DefaultInstrumentationName = "github.com/mahboubii/grpcmetrics"
 )
 
+// errorTypeKey is error.type attribute which is not available in semconv/v1.21.0 yet.
+var errorTypeKey = attribute.Key("error.type")
+
 // rpcInfo is data used for recording metrics about the rpc attempt client side, and the overall rpc server side.
 type rpcInfo struct {
 	fullMethodName string
-
-	// access these counts atomically for hedging in the future
+	tagInfo        *stats.RPCTagInfo
+	// rpc.grpc.method_type, empty unless WithMethodTypeAttribute is used
+	methodType string
+	// network attributes of the connection taken from TagConn, only available on servers
+	netAttrs []attribute.KeyValue
+	// attributes of the caller taken in TagRPC added to every instrument, only available on servers
+	tagAttrs []attribute.KeyValue
+	// number of previous attempts of the rpc, only available on servers with WithRetryAttempts
+	retryAttempt int
+	// deadline of the rpc context, zero when it has none
+	deadline time.Time
+	// attributes known when the rpc begins, before its status is available
+	methodAttrs attribute.Set
+	// attributes added by the application while handling the rpc
+	labeler Labeler
+	// allowlisted request metadata attributes
+	metadataAttrs []attribute.KeyValue
+
+	// call the attempt belongs to, nil unless the client interceptors are used
+	call *callInfo
+	// whether the attempt is a transparent retry of a previous attempt
+	transparentRetry bool
+
+	// access these counts atomically since they are read by collections while the rpc is in-flight
 	// number of messages sent from side (client || server)
 	sentMsgs int64
 	// number of bytes sent (within each message) from side (client || server)
@@ -35,6 +65,36 @@ type rpcInfo struct {
 	recvMsgs int64
 	// number of bytes received (within each message) received on side (client || server)
 	recvBytes int64
+	// number of bytes sent and received on the wire, including compression and framing
+	sentWireBytes int64
+	recvWireBytes int64
+	// number of compressed bytes sent and received (within each message)
+	sentCompressedBytes int64
+	recvCompressedBytes int64
+
+	// mu guards the fields taken from headers which may be handled concurrently with End on the client side.
+	mu           sync.Mutex
+	sentEncoding string
+	recvEncoding string
+	peerAddr     net.Addr
+	localAddr    net.Addr
+
+	// set once the rpc is counted as having oversized metadata
+	metadataOversized int32
+	// set once trailers are received by clients or sent by servers
+	trailers int32
+
+	// unix nano timestamps of the latency phases
+	firstHeaderTime   int64
+	firstResponseTime int64
+	lastMessageTime   int64
+
+	// stream cadence, only tracked when either side is streaming
+	isStream              int32
+	streamLastSentTime    int64
+	streamLastRecvTime    int64
+	streamLastMessageTime int64
+	streamMaxIdle         int64
 }
 
 type rpcInfoKey struct{}
@@ -68,157 +128,691 @@ func getRPCStatus(err error) *status.Status {
 	return status.New(codes.Internal, err.Error())
 }
 
-func getAttributes(fullMethodName string, err error) attribute.Set {
-	rpcStatus := getRPCStatus(err)
+// appendMethodAttributes appends the attributes identifying the rpc system, service, method and method type if known.
+// Malformed method names are recorded as "_OTHER".
+func appendMethodAttributes(attr []attribute.KeyValue, fullMethodName, methodType string) []attribute.KeyValue {
+	attr = append(attr, semconv.RPCSystemGRPC)
+
+	if methodType != "" {
+		attr = append(attr, methodTypeKey.String(methodType))
+	}
+
+	parts := strings.Split(fullMethodName, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" { //nolint:gomnd
+		parts = []string{"", otherValue, otherValue}
+	}
+
+	attr = append(attr, semconv.RPCServiceKey.String(parts[1]))
+	attr = append(attr, semconv.RPCMethodKey.String(parts[2]))
 
+	return attr
+}
+
+// getMethodAttributes returns the attributes known when the rpc begins, extra attributes are added first.
+func getMethodAttributes(fullMethodName, methodType string, extra []attribute.KeyValue) attribute.Set {
+	attr := make([]attribute.KeyValue, 0, 4+len(extra)) //nolint:gomnd
+	attr = append(attr, extra...)
+
+	return attribute.NewSet(appendMethodAttributes(attr, fullMethodName, methodType)...)
+}
+
+// getStatusAttributes returns the attributes of a finished rpc for SemconvLegacy or SemconvStable.
+// errorType is recorded as error.type in every mode when not empty, SemconvStable defaults it to the name of the status code.
+// extra attributes are added first so they can not override the standard ones.
+func getStatusAttributes(mode SemconvMode, fullMethodName, methodType string, rpcStatus *status.Status, errorType string, extra []attribute.KeyValue) attribute.Set {
 	// https://opentelemetry.io/docs/reference/specification/metrics/semantic_conventions/rpc-metrics/
-	attr := make([]attribute.KeyValue, 0, 5) //nolint:gomnd
-	attr = append(attr, semconv.RPCSystemGRPC)
+	attr := make([]attribute.KeyValue, 0, 7+len(extra)) //nolint:gomnd
+	attr = append(attr, extra...)
 	attr = append(attr, semconv.RPCGRPCStatusCodeKey.Int(int(rpcStatus.Code())))
-	attr = append(attr, attribute.Key("rpc.grpc.status").String(rpcStatus.Code().String()))
 
-	parts := strings.Split(fullMethodName, "/")
-	if len(parts) == 3 { //nolint:gomnd
-		attr = append(attr, semconv.RPCServiceKey.String(parts[1]))
-		attr = append(attr, semconv.RPCMethodKey.String(parts[2]))
+	if mode != SemconvStable {
+		attr = append(attr, attribute.Key("rpc.grpc.status").String(rpcStatus.Code().String()))
+	}
+
+	if errorType != "" {
+		attr = append(attr, errorTypeKey.String(errorType))
+	} else if mode != SemconvLegacy && rpcStatus.Code() != codes.OK {
+		attr = append(attr, errorTypeKey.String(rpcStatus.Code().String()))
 	}
 
-	return attribute.NewSet(attr...)
+	return attribute.NewSet(appendMethodAttributes(attr, fullMethodName, methodType)...)
+}
+
+// getServerAttributes returns server.address and server.port of addr.
+func getServerAttributes(addr net.Addr) []attribute.KeyValue {
+	if addr == nil {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return []attribute.KeyValue{semconv.ServerAddress(addr.String())}
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return []attribute.KeyValue{semconv.ServerAddress(host)}
+	}
+
+	return []attribute.KeyValue{semconv.ServerAddress(host), semconv.ServerPort(p)}
 }
 
 // Handler implements https://pkg.go.dev/google.golang.org/grpc/stats#Handler
 type Handler struct {
 	isClient bool
 
+	semconvMode SemconvMode
+
 	rpcDuration     metric.Float64Histogram
+	rpcCallDuration metric.Float64Histogram
 	rpcRequestSize  metric.Int64Histogram
 	rpcResponseSize metric.Int64Histogram
+	wireSizes       *wireSizes
+	metadataSizes   *metadataSizes
+	latencyPhases   *latencyPhases
+	streamCadence   *streamCadence
+	interim         *interimReporter
+	inFlight        *inFlight
+	zoneTraffic     *zoneTraffic
+	attempts        *attempts
+	deadlines       *deadlines
+	// whether message sizes are accumulated in rpcInfo
+	countBytes bool
+
+	// size of each message, only used when WithPerMessageSizes is enabled.
+	rpcRequestMessageSize  metric.Int64Histogram
+	rpcResponseMessageSize metric.Int64Histogram
 
 	// RFC suggests using histogram for counts mostly for Streams
-	// It lead to high cardinality of lables so we are using counter.
+	// It lead to high cardinality of lables so we are using counter by default.
 	rpcRequestsPerRPC  metric.Int64Counter
 	rpcResponsesPerRPC metric.Int64Counter
-}
 
-func newHandler(isClient bool, options []Option) (*Handler, error) {
-	c := config{}
+	rpcRequestsPerRPCHistogram  metric.Int64Histogram
+	rpcResponsesPerRPCHistogram metric.Int64Histogram
+
+	filters            []Filter
+	attributesFunc     AttributesFunc
+	metadataAttributes *metadataAttributes
+	// whether rpc.grpc.method_type is recorded
+	methodType bool
+	// whether error.origin is recorded
+	errorOrigin bool
+	// nil unless WithNetworkAttributes is used
+	network *networkAttributes
+	// nil unless WithCallerIdentity or WithTLSAttributes is used on a server
+	caller *callerAttributes
+	// nil unless WithErrorClassifier is used
+	errorTypes *errorTypes
+
+	// registered methods by full method name, nil unless RegisterServices is used.
+	methods atomic.Pointer[map[string]grpc.MethodInfo]
+
+	rpcStarted metric.Int64Counter
+	rpcActive  metric.Int64UpDownCounter
+	// tracks per method high-water mark of rpcActive, nil when disabled.
+	activeRPCs *activeRPCs
+	// nil unless WithRetryAttempts is used on a server
+	retriedRequests metric.Int64Counter
+
+	connOpened   metric.Int64Counter
+	connClosed   metric.Int64Counter
+	connActive   metric.Int64UpDownCounter
+	connDuration metric.Float64Histogram
+}
 
-	for _, o := range options {
-		o.apply(&c)
-	}
+// callDurationBuckets are the bucket boundaries in seconds recommended by the rpc semantic conventions.
+var callDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
 
-	if c.meterProvider == nil {
-		c.meterProvider = otel.GetMeterProvider()
-	}
+// messagesPerRPCBuckets grow exponentially to spot the rare streams with tens of thousands of messages.
+var messagesPerRPCBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 4096, 16384, 65536}
 
-	if c.instrumentationName == "" {
-		c.instrumentationName = DefaultInstrumentationName
-	}
+func newHandler(isClient bool, options []Option) (*Handler, error) {
+	c := newConfig(options)
 
 	// metrics from https://opentelemetry.io/docs/reference/specification/metrics/semantic_conventions/rpc-metrics/
 	meter := c.meterProvider.Meter(c.instrumentationName)
 
 	var err error
 
-	h := &Handler{isClient: isClient}
+	h := &Handler{
+		isClient:           isClient,
+		semconvMode:        c.semconvMode,
+		filters:            c.filters,
+		attributesFunc:     c.attributesFunc,
+		metadataAttributes: newMetadataAttributes(c),
+		methodType:         c.methodTypeAttribute,
+		errorOrigin:        c.errorOrigin,
+		network:            newNetworkAttributes(c, isClient),
+		caller:             newCallerAttributes(c, isClient),
+		errorTypes:         newErrorTypes(c),
+	}
 
 	prefix := "rpc.server"
 	if h.isClient {
 		prefix = "rpc.client"
 	}
 
-	h.rpcRequestsPerRPC, err = meter.Int64Counter(prefix+".requests_per_rpc", metric.WithUnit("1"))
+	if c.messagesPerRPCMode != MessagesPerRPCHistogram {
+		h.rpcRequestsPerRPC, err = meter.Int64Counter(prefix+".requests_per_rpc", metric.WithUnit("1"))
+		if err != nil {
+			return nil, err
+		}
+
+		h.rpcResponsesPerRPC, err = meter.Int64Counter(prefix+".responses_per_rpc", metric.WithUnit("1"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.messagesPerRPCMode != MessagesPerRPCCounter {
+		suffix := ""
+		if c.messagesPerRPCMode == MessagesPerRPCBoth {
+			suffix = ".histogram"
+		}
+
+		h.rpcRequestsPerRPCHistogram, err = meter.Int64Histogram(prefix+".requests_per_rpc"+suffix, metric.WithUnit("1"), metric.WithExplicitBucketBoundaries(messagesPerRPCBuckets...))
+		if err != nil {
+			return nil, err
+		}
+
+		h.rpcResponsesPerRPCHistogram, err = meter.Int64Histogram(prefix+".responses_per_rpc"+suffix, metric.WithUnit("1"), metric.WithExplicitBucketBoundaries(messagesPerRPCBuckets...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h.rpcStarted, err = meter.Int64Counter(prefix+".started", metric.WithUnit("1"))
 	if err != nil {
 		return nil, err
 	}
 
-	h.rpcResponsesPerRPC, err = meter.Int64Counter(prefix+".responses_per_rpc", metric.WithUnit("1"))
+	h.rpcActive, err = meter.Int64UpDownCounter(prefix+".active_rpcs", metric.WithUnit("1"))
 	if err != nil {
 		return nil, err
 	}
 
-	if c.instrumentLatency {
+	if c.retryAttempts && !isClient {
+		h.retriedRequests, err = meter.Int64Counter(prefix+".retried_requests", metric.WithUnit("1"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.instrumentActiveRPCsMax {
+		h.activeRPCs = newActiveRPCs()
+
+		_, err = meter.Int64ObservableGauge(prefix+".active_rpcs.max", metric.WithUnit("1"), metric.WithInt64Callback(h.activeRPCs.observe))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h.connOpened, err = meter.Int64Counter(prefix+".connections.opened", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	h.connClosed, err = meter.Int64Counter(prefix+".connections.closed", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	h.connActive, err = meter.Int64UpDownCounter(prefix+".connections.active", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.instrumentConnectionDuration {
+		h.connDuration, err = meter.Float64Histogram(prefix+".connection.duration", metric.WithUnit(durationUnit(c.semconvMode)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.instrumentLatency && c.semconvMode != SemconvStable {
 		h.rpcDuration, err = meter.Float64Histogram(prefix+".duration", metric.WithUnit("ms"))
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if c.instrumentLatency && c.semconvMode != SemconvLegacy {
+		h.rpcCallDuration, err = meter.Float64Histogram(prefix+".call.duration", metric.WithUnit("s"), metric.WithExplicitBucketBoundaries(callDurationBuckets...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if c.instrumentSizes {
-		h.rpcRequestSize, err = meter.Int64Histogram(prefix+".request.size", metric.WithUnit("By"))
+		requestSizeName, responseSizeName := prefix+".request.size", prefix+".response.size"
+
+		if c.perMessageSizes {
+			h.rpcRequestMessageSize, err = meter.Int64Histogram(requestSizeName, metric.WithUnit("By"))
+			if err != nil {
+				return nil, err
+			}
+
+			h.rpcResponseMessageSize, err = meter.Int64Histogram(responseSizeName, metric.WithUnit("By"))
+			if err != nil {
+				return nil, err
+			}
+
+			// per rpc totals are kept under a separate name
+			requestSizeName, responseSizeName = prefix+".request.total_size", prefix+".response.total_size"
+		}
+
+		h.rpcRequestSize, err = meter.Int64Histogram(requestSizeName, metric.WithUnit("By"))
 		if err != nil {
 			return nil, err
 		}
 
-		h.rpcResponseSize, err = meter.Int64Histogram(prefix+".response.size", metric.WithUnit("By"))
+		h.rpcResponseSize, err = meter.Int64Histogram(responseSizeName, metric.WithUnit("By"))
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if c.instrumentWireSizes {
+		h.wireSizes, err = newWireSizes(meter, prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h.metadataSizes, err = newMetadataSizes(meter, prefix, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.instrumentStreamCadence {
+		h.streamCadence, err = newStreamCadence(meter, prefix, c.semconvMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.instrumentLatencyPhases {
+		h.latencyPhases, err = newLatencyPhases(meter, prefix, isClient, c.semconvMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.stuckRPCThreshold > 0 {
+		h.inFlight = newInFlight(c.stuckRPCThreshold, c.stuckRPCsFunc)
+
+		_, err = meter.Int64ObservableGauge(prefix+".stuck_rpcs", metric.WithUnit("1"), metric.WithInt64Callback(h.inFlight.observe))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.instrumentAttempts && isClient {
+		h.attempts, err = newAttempts(meter, prefix, c.semconvMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.instrumentDeadlines {
+		h.deadlines, err = newDeadlines(meter, prefix, c.semconvMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.zoneResolver != nil {
+		h.zoneTraffic, err = newZoneTraffic(meter, prefix, c.zoneResolver)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// created last since it starts a goroutine with an interval.
+	if c.interimReporting {
+		h.interim, err = newInterimReporter(meter, prefix, isClient, c.interimInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h.countBytes = h.rpcRequestSize != nil || h.wireSizes != nil || h.interim != nil || h.zoneTraffic != nil
+
 	return h, nil
 }
 
-func NewServerHandler(options ...Option) (stats.Handler, error) {
+var _ stats.Handler = (*Handler)(nil)
+
+// NewServerHandler returns a Handler to use with grpc.StatsHandler server option.
+// Handler implements stats.Handler, it is returned as *Handler to expose RegisterServices, InFlight and Close.
+func NewServerHandler(options ...Option) (*Handler, error) {
 	return newHandler(false, options)
 }
 
-func NewClientHandler(options ...Option) (stats.Handler, error) {
+// NewClientHandler returns a Handler to use with grpc.WithStatsHandler dial option.
+// Handler implements stats.Handler, it is returned as *Handler to expose InFlight and Close.
+func NewClientHandler(options ...Option) (*Handler, error) {
 	return newHandler(true, options)
 }
 
-// TagConn exists to satisfy gRPC stats.Handler interface.
-func (h *Handler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+// Close stops the background reporting started by WithInterimReporting, it does not shutdown the meter provider.
+func (h *Handler) Close() error {
+	if h.interim != nil {
+		return h.interim.close()
+	}
 
-// HandleConn exists to satisfy gRPC stats.Handler interface.
-func (h *Handler) HandleConn(_ context.Context, _ stats.ConnStats) {}
+	return nil
+}
 
+// TagRPC attaches the rpc info used by HandleRPC to the context, unless the rpc is rejected by a Filter.
 func (h *Handler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
-	return setRPCInfo(ctx, &rpcInfo{fullMethodName: info.FullMethodName})
+	for _, f := range h.filters {
+		if !f(info) {
+			// ctx can carry the rpcInfo of another rpc, e.g. of the server method making this client call,
+			// so mark it as not instrumented instead of recording the events of this rpc on it.
+			return setRPCInfo(ctx, nil)
+		}
+	}
+
+	fullMethodName := info.FullMethodName
+	if !h.knownMethod(fullMethodName) {
+		fullMethodName = otherMethod
+	}
+
+	ri := &rpcInfo{
+		fullMethodName: fullMethodName,
+		tagInfo:        info,
+	}
+
+	// servers handle the received headers before Begin, registered services make the method type known from the start.
+	if h.methodType && !h.isClient {
+		if m, ok := h.registeredMethod(fullMethodName); ok {
+			ri.methodType = getMethodType(m.IsClientStream, m.IsServerStream)
+		}
+	}
+
+	if h.caller != nil {
+		ri.tagAttrs = h.caller.get(ctx)
+	}
+
+	// unlike stats.InHeader.Header, grpc-previous-rpc-attempts is available in the incoming metadata for every server transport.
+	if h.retriedRequests != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ri.retryAttempt = getRetryAttempt(md)
+		ri.tagAttrs = append(ri.tagAttrs, retryAttemptKey.String(retryAttemptValue(ri.retryAttempt)))
+	}
+
+	// each attempt of a call owned by the client interceptors is tagged with the context of the call.
+	if h.attempts != nil {
+		if ci := getCallInfo(ctx); ci != nil {
+			ri.call = ci
+			atomic.AddInt64(&ci.attempts, 1)
+		}
+	}
+
+	ri.methodAttrs = getMethodAttributes(fullMethodName, ri.methodType, ri.tagAttrs)
+
+	// gRPC servers consume the grpc-timeout header and set it as the deadline of the rpc context before TagRPC.
+	if h.deadlines != nil {
+		ri.deadline, _ = ctx.Deadline()
+	}
+
+	// client rpc contexts are not derived from the connection context, but may be from the context of a server rpc.
+	if h.network != nil && !h.isClient {
+		if ci := getConnInfo(ctx); ci != nil {
+			ri.netAttrs = ci.netAttrs
+		}
+	}
+
+	if h.metadataAttributes != nil {
+		// unlike stats.InHeader.Header, incoming metadata is available in the context for every server transport.
+		var md metadata.MD
+		if h.isClient {
+			md, _ = metadata.FromOutgoingContext(ctx)
+		} else {
+			md, _ = metadata.FromIncomingContext(ctx)
+		}
+
+		ri.metadataAttrs = h.metadataAttributes.get(md)
+	}
+
+	return setRPCInfo(ctx, ri)
 }
 
 // HandleRPC implements per-RPC stats instrumentation.
 func (h *Handler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
-	// this should never be null, but we always check, just to be sure.
+	// rpcs rejected by a Filter are not tagged and end up here without an rpcInfo.
 	ri := getRPCInfo(ctx)
 	if ri == nil {
 		return
 	}
 
+	// use a new context since original ctx could be canceled during this state.
+	subCtx := context.Background()
+
 	switch rs := rs.(type) {
-	case *stats.InHeader, *stats.OutHeader, *stats.InTrailer, *stats.OutTrailer:
-		// Headers and Trailers are not relevant to the measures
+	case *stats.InHeader:
+		ri.mu.Lock()
+		ri.recvEncoding = rs.Compression
+		if !h.isClient {
+			ri.peerAddr = rs.RemoteAddr
+			ri.localAddr = rs.LocalAddr
+		}
+		ri.mu.Unlock()
+
+		if h.metadataSizes != nil {
+			h.metadataSizes.record(subCtx, ri, "header", "received", rs.Header, rs.WireLength)
+		}
+
+		if h.latencyPhases != nil && h.isClient {
+			h.latencyPhases.header(ri)
+		}
+	case *stats.OutHeader:
+		ri.mu.Lock()
+		ri.sentEncoding = rs.Compression
+		if h.isClient {
+			ri.peerAddr = rs.RemoteAddr
+			ri.localAddr = rs.LocalAddr
+		}
+		ri.mu.Unlock()
+
+		if h.metadataSizes != nil {
+			h.metadataSizes.record(subCtx, ri, "header", "sent", rs.Header, 0)
+		}
+	case *stats.InTrailer:
+		atomic.StoreInt32(&ri.trailers, 1)
+
+		if h.metadataSizes != nil {
+			h.metadataSizes.record(subCtx, ri, "trailer", "received", rs.Trailer, rs.WireLength)
+		}
+	case *stats.OutTrailer:
+		atomic.StoreInt32(&ri.trailers, 1)
+
+		if h.metadataSizes != nil {
+			h.metadataSizes.record(subCtx, ri, "trailer", "sent", rs.Trailer, 0)
+		}
 	case *stats.Begin:
-		// Potentially measure total number of client RPCs ever opened, including those that have not completed.
+		// Begin is handled before any other event except the received headers on servers, so methodAttrs is not read concurrently.
+		if h.methodType && ri.methodType == "" {
+			ri.methodType = getMethodType(rs.IsClientStream, rs.IsServerStream)
+			ri.methodAttrs = getMethodAttributes(ri.fullMethodName, ri.methodType, ri.tagAttrs)
+		}
+
+		h.rpcStarted.Add(subCtx, 1, metric.WithAttributeSet(ri.methodAttrs))
+		h.rpcActive.Add(subCtx, 1, metric.WithAttributeSet(ri.methodAttrs))
+
+		if h.retriedRequests != nil && ri.retryAttempt > 0 {
+			h.retriedRequests.Add(subCtx, 1, metric.WithAttributeSet(ri.methodAttrs))
+		}
+
+		if h.activeRPCs != nil {
+			h.activeRPCs.inc(ri.methodAttrs)
+		}
+
+		if h.streamCadence != nil {
+			h.streamCadence.begin(ri, rs)
+		}
+
+		if h.interim != nil {
+			h.interim.begin(ri)
+		}
+
+		if h.inFlight != nil {
+			h.inFlight.begin(ri, rs.BeginTime)
+		}
+
+		if h.attempts != nil {
+			h.attempts.begin(subCtx, ri, rs)
+		}
+
+		if h.deadlines != nil {
+			h.deadlines.begin(subCtx, ri, rs.BeginTime)
+		}
 	case *stats.InPayload:
 		atomic.AddInt64(&ri.recvMsgs, 1)
 
-		if h.rpcRequestSize != nil {
+		if h.countBytes {
 			atomic.AddInt64(&ri.recvBytes, int64(rs.Length))
+			atomic.AddInt64(&ri.recvWireBytes, int64(rs.WireLength))
+			atomic.AddInt64(&ri.recvCompressedBytes, int64(rs.CompressedLength))
+		}
+
+		if h.latencyPhases != nil {
+			h.latencyPhases.message(ri, rs.RecvTime, h.isClient)
+		}
+
+		if h.streamCadence != nil {
+			h.streamCadence.message(subCtx, ri, rs.RecvTime, false)
+		}
+
+		if h.rpcRequestMessageSize != nil {
+			if h.isClient {
+				h.rpcResponseMessageSize.Record(subCtx, int64(rs.Length), metric.WithAttributeSet(ri.methodAttrs))
+			} else {
+				h.rpcRequestMessageSize.Record(subCtx, int64(rs.Length), metric.WithAttributeSet(ri.methodAttrs))
+			}
 		}
 	case *stats.OutPayload:
 		atomic.AddInt64(&ri.sentMsgs, 1)
 
-		if h.rpcResponseSize != nil {
+		if h.countBytes {
 			atomic.AddInt64(&ri.sentBytes, int64(rs.Length))
+			atomic.AddInt64(&ri.sentWireBytes, int64(rs.WireLength))
+			atomic.AddInt64(&ri.sentCompressedBytes, int64(rs.CompressedLength))
+		}
+
+		if h.latencyPhases != nil {
+			h.latencyPhases.message(ri, rs.SentTime, !h.isClient)
+		}
+
+		if h.streamCadence != nil {
+			h.streamCadence.message(subCtx, ri, rs.SentTime, true)
+		}
+
+		if h.rpcRequestMessageSize != nil {
+			if h.isClient {
+				h.rpcRequestMessageSize.Record(subCtx, int64(rs.Length), metric.WithAttributeSet(ri.methodAttrs))
+			} else {
+				h.rpcResponseMessageSize.Record(subCtx, int64(rs.Length), metric.WithAttributeSet(ri.methodAttrs))
+			}
 		}
 	case *stats.End:
-		// use a new context since original ctx could be canceled during this state.
-		subCtx := context.Background()
+		h.rpcActive.Add(subCtx, -1, metric.WithAttributeSet(ri.methodAttrs))
+
+		if h.activeRPCs != nil {
+			h.activeRPCs.dec(ri.methodAttrs)
+		}
 
-		attrs := getAttributes(ri.fullMethodName, rs.Error)
+		if h.interim != nil {
+			h.interim.end(ri)
+		}
+
+		if h.inFlight != nil {
+			h.inFlight.end(ri)
+		}
+
+		rpcStatus, errorType := h.classifyError(rs.Error)
+
+		extra := append(ri.labeler.Get(), ri.metadataAttrs...)
+		extra = append(extra, ri.tagAttrs...)
+
+		if h.errorOrigin {
+			extra = append(extra, errorOriginAttributes(getErrorOrigin(ctx, ri, rs, rpcStatus.Code(), h.isClient))...)
+		}
+		if h.attributesFunc != nil {
+			extra = append(extra, h.attributesFunc(ctx, ri.tagInfo, rpcStatus, rs)...)
+		}
 
+		ri.mu.Lock()
+		localAddr, peerAddr := ri.localAddr, ri.peerAddr
+		ri.mu.Unlock()
+
+		if h.network != nil {
+			netAttrs := ri.netAttrs
+			if netAttrs == nil {
+				netAttrs = h.network.get(localAddr, peerAddr)
+			}
+
+			extra = append(extra, netAttrs...)
+		}
+
+		var zoneAttrs []attribute.KeyValue
+		if h.zoneTraffic != nil {
+			zoneAttrs = h.zoneTraffic.get(localAddr, peerAddr)
+			extra = append(extra, zoneAttrs...)
+		}
+
+		// in duplicate mode only the stable call duration gets the stable attributes,
+		// every other instrument keeps the legacy ones to not change the series of existing dashboards.
+		attrs := getStatusAttributes(SemconvLegacy, ri.fullMethodName, ri.methodType, rpcStatus, errorType, extra)
+		stableAttrs := attrs
+
+		if h.semconvMode != SemconvLegacy {
+			var serverAttrs []attribute.KeyValue
+			// the remote address of clients is the resolved backend rather than the server name they dialed.
+			if !h.isClient {
+				serverAttrs = getServerAttributes(localAddr)
+			}
+
+			stableAttrs = getStatusAttributes(SemconvStable, ri.fullMethodName, ri.methodType, rpcStatus, errorType, append(extra, serverAttrs...))
+		}
+
+		if h.semconvMode == SemconvStable {
+			attrs = stableAttrs
+		}
+
+		requests, responses := atomic.LoadInt64(&ri.recvMsgs), atomic.LoadInt64(&ri.sentMsgs)
 		if h.isClient {
 			// gRPC stats handler treats client stats exactly similar to server stats while technically name should be reversed.
-			h.rpcRequestsPerRPC.Add(subCtx, atomic.LoadInt64(&ri.sentMsgs), metric.WithAttributeSet(attrs))
-			h.rpcResponsesPerRPC.Add(subCtx, atomic.LoadInt64(&ri.recvMsgs), metric.WithAttributeSet(attrs))
-		} else {
-			h.rpcRequestsPerRPC.Add(subCtx, atomic.LoadInt64(&ri.recvMsgs), metric.WithAttributeSet(attrs))
-			h.rpcResponsesPerRPC.Add(subCtx, atomic.LoadInt64(&ri.sentMsgs), metric.WithAttributeSet(attrs))
+			requests, responses = responses, requests
+		}
+
+		if h.rpcRequestsPerRPC != nil {
+			h.rpcRequestsPerRPC.Add(subCtx, requests, metric.WithAttributeSet(attrs))
+			h.rpcResponsesPerRPC.Add(subCtx, responses, metric.WithAttributeSet(attrs))
+		}
+
+		if h.rpcRequestsPerRPCHistogram != nil {
+			h.rpcRequestsPerRPCHistogram.Record(subCtx, requests, metric.WithAttributeSet(attrs))
+			h.rpcResponsesPerRPCHistogram.Record(subCtx, responses, metric.WithAttributeSet(attrs))
 		}
 
 		if h.rpcDuration != nil {
-			h.rpcDuration.Record(subCtx, float64(time.Since(rs.BeginTime).Milliseconds()), metric.WithAttributeSet(attrs))
+			h.rpcDuration.Record(subCtx, float64(rs.EndTime.Sub(rs.BeginTime).Milliseconds()), metric.WithAttributeSet(attrs))
+		}
+
+		if h.attempts != nil {
+			h.attempts.end(subCtx, ri, rs, attrs)
+		}
+
+		if h.deadlines != nil {
+			h.deadlines.end(subCtx, ri, rs, attrs)
+		}
+
+		// calls owned by the client interceptors record their call duration once the call ends instead of per attempt.
+		if h.rpcCallDuration != nil && ri.call == nil {
+			h.rpcCallDuration.Record(subCtx, rs.EndTime.Sub(rs.BeginTime).Seconds(), metric.WithAttributeSet(stableAttrs))
 		}
 
 		if h.rpcRequestSize != nil {
@@ -237,6 +831,22 @@ func (h *Handler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
 			}
 		}
 
+		if h.zoneTraffic != nil {
+			h.zoneTraffic.record(subCtx, ri, zoneAttrs)
+		}
+
+		if h.wireSizes != nil {
+			h.wireSizes.record(subCtx, ri, h.isClient, attrs)
+		}
+
+		if h.latencyPhases != nil {
+			h.latencyPhases.record(subCtx, ri, rs, attrs)
+		}
+
+		if h.streamCadence != nil {
+			h.streamCadence.record(subCtx, ri, rs, attrs)
+		}
+
 	default:
 		otel.Handle(fmt.Errorf("received unhandled stats with type (%T) and data: %v", rs, rs))
 	}
@@ -2,10 +2,18 @@ package grpcmetrics
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,12 +23,17 @@ import (
 	"go.opentelemetry.io/otel/metric/noop"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/metric/metricdata"
-	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
@@ -39,10 +52,30 @@ func TestGetRPCStatus(t *testing.T) {
 	assert.Equal(t, status.New(codes.OK, "OK"), getRPCStatus(nil))
 	assert.Equal(t, codes.Internal, getRPCStatus(errors.New("non rpc err")).Code())
 	assert.Equal(t, codes.NotFound, getRPCStatus(status.Error(codes.NotFound, "")).Code())
+	// context errors are only mapped to their status by DefaultErrorClassifier to not change existing series
+	assert.Equal(t, codes.Internal, getRPCStatus(context.DeadlineExceeded).Code())
 }
 
-func TestGetAttributes(t *testing.T) {
-	listAttrs := getAttributes("/product.Products/ListTags", nil)
+func TestDefaultErrorClassifier(t *testing.T) {
+	s, errorType := DefaultErrorClassifier(context.DeadlineExceeded)
+	assert.Equal(t, codes.DeadlineExceeded, s.Code())
+	assert.Equal(t, "DeadlineExceeded", errorType)
+
+	s, errorType = DefaultErrorClassifier(fmt.Errorf("dial: %w", context.Canceled))
+	assert.Equal(t, codes.Canceled, s.Code())
+	assert.Equal(t, "Canceled", errorType)
+
+	s, errorType = DefaultErrorClassifier(status.Error(codes.NotFound, ""))
+	assert.Equal(t, codes.NotFound, s.Code())
+	assert.Equal(t, "NotFound", errorType)
+
+	s, errorType = DefaultErrorClassifier(errors.New("non rpc err"))
+	assert.Equal(t, codes.Internal, s.Code())
+	assert.Equal(t, "Internal", errorType)
+}
+
+func TestGetStatusAttributes(t *testing.T) {
+	listAttrs := getStatusAttributes(SemconvLegacy, "/product.Products/ListTags", "", getRPCStatus(nil), "", nil)
 	assert.ElementsMatch(t,
 		[]attribute.KeyValue{
 			semconv.RPCSystemGRPC,
@@ -54,7 +87,7 @@ func TestGetAttributes(t *testing.T) {
 		listAttrs.ToSlice(),
 	)
 
-	listAttrsErr := getAttributes("/product.Products/ListTags", status.Error(codes.InvalidArgument, ""))
+	listAttrsErr := getStatusAttributes(SemconvLegacy, "/product.Products/ListTags", "", getRPCStatus(status.Error(codes.InvalidArgument, "")), "", nil)
 
 	assert.ElementsMatch(t,
 		[]attribute.KeyValue{
@@ -66,6 +99,562 @@ func TestGetAttributes(t *testing.T) {
 		},
 		listAttrsErr.ToSlice(),
 	)
+
+	malformedAttrs := getStatusAttributes(SemconvLegacy, "product.Products.ListTags", "", getRPCStatus(nil), "", nil)
+
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			semconv.RPCSystemGRPC,
+			semconv.RPCGRPCStatusCodeKey.Int(0),
+			attribute.Key("rpc.grpc.status").String("OK"),
+			semconv.RPCServiceKey.String("_OTHER"),
+			semconv.RPCMethodKey.String("_OTHER"),
+		},
+		malformedAttrs.ToSlice(),
+	)
+
+	// extra attributes can not override the standard ones
+	stableAttrs := getStatusAttributes(SemconvStable, "/product.Products/ListTags", "server_streaming", status.New(codes.NotFound, ""), "", []attribute.KeyValue{
+		semconv.RPCMethodKey.String("Override"),
+		attribute.String("tenant", "acme"),
+	})
+
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			semconv.RPCSystemGRPC,
+			semconv.RPCGRPCStatusCodeKey.Int(5),
+			attribute.Key("error.type").String("NotFound"),
+			semconv.RPCServiceKey.String("product.Products"),
+			semconv.RPCMethodKey.String("ListTags"),
+			attribute.Key("rpc.grpc.method_type").String("server_streaming"),
+			attribute.String("tenant", "acme"),
+		},
+		stableAttrs.ToSlice(),
+	)
+}
+
+func TestRegisterServices(t *testing.T) {
+	s := grpc.NewServer()
+	testserver.RegisterTestsServiceServer(s, &testserver.Server{})
+
+	h, err := NewServerHandler()
+	assert.NoError(t, err)
+
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/evil.Scanner/Probe"})
+	assert.Equal(t, "/evil.Scanner/Probe", getRPCInfo(ctx).fullMethodName)
+
+	assert.NoError(t, h.RegisterServices(s))
+
+	ctx = h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"})
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			semconv.RPCSystemGRPC,
+			semconv.RPCServiceKey.String("testserver.TestsService"),
+			semconv.RPCMethodKey.String("Ok"),
+		},
+		getRPCInfo(ctx).methodAttrs.ToSlice(),
+	)
+
+	ctx = h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/evil.Scanner/Probe"})
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			semconv.RPCSystemGRPC,
+			semconv.RPCServiceKey.String("_OTHER"),
+			semconv.RPCMethodKey.String("_OTHER"),
+		},
+		getRPCInfo(ctx).methodAttrs.ToSlice(),
+	)
+
+	client, err := NewClientHandler()
+	assert.NoError(t, err)
+	assert.Error(t, client.RegisterServices(s))
+}
+
+func TestMethodType(t *testing.T) {
+	assert.Equal(t, "unary", getMethodType(false, false))
+	assert.Equal(t, "client_streaming", getMethodType(true, false))
+	assert.Equal(t, "server_streaming", getMethodType(false, true))
+	assert.Equal(t, "bidi_streaming", getMethodType(true, true))
+
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithMethodTypeAttribute(true))
+	cli, cMetrics := newTestClient(t, lis, WithMethodTypeAttribute(true))
+
+	_, err := cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	res, err := cli.Stream(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	for {
+		if _, err := res.Recv(); err != nil {
+			assert.ErrorIs(t, io.EOF, err)
+
+			break
+		}
+	}
+
+	attrs := func(method, methodType string) []attribute.KeyValue {
+		return []attribute.KeyValue{
+			{Key: "rpc.grpc.method_type", Value: attribute.StringValue(methodType)},
+			{Key: "rpc.method", Value: attribute.StringValue(method)},
+			{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+			{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+		}
+	}
+
+	for side, rm := range map[string]metricdata.ResourceMetrics{"server": sMetrics(), "client": cMetrics()} {
+		values := map[string]int64{}
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != "rpc."+side+".started" {
+				continue
+			}
+
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+				method, _ := dp.Attributes.Value("rpc.method")
+				methodType, _ := dp.Attributes.Value("rpc.grpc.method_type")
+				values[method.AsString()+" "+methodType.AsString()] = dp.Value
+
+				if method.AsString() == "Ok" {
+					assert.ElementsMatch(t, attrs("Ok", "unary"), dp.Attributes.ToSlice())
+				}
+			}
+		}
+
+		assert.Equal(t, map[string]int64{"Ok unary": 1, "Stream server_streaming": 1}, values, side)
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != "rpc."+side+".duration" {
+				continue
+			}
+
+			for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints { //nolint:forcetypeassert
+				method, _ := dp.Attributes.Value("rpc.method")
+				methodType, _ := dp.Attributes.Value("rpc.grpc.method_type")
+				values[method.AsString()+" "+methodType.AsString()] -= int64(dp.Count)
+			}
+		}
+
+		assert.Equal(t, map[string]int64{"Ok unary": 0, "Stream server_streaming": 0}, values, side)
+	}
+
+	// registered services make the method type known before Begin
+	s := grpc.NewServer()
+	testserver.RegisterTestsServiceServer(s, &testserver.Server{})
+
+	h, err := NewServerHandler(WithMethodTypeAttribute(true))
+	assert.NoError(t, err)
+	assert.NoError(t, h.RegisterServices(s))
+
+	rpcCtx := h.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Stream"})
+	assert.ElementsMatch(t, attrs("Stream", "server_streaming"), getRPCInfo(rpcCtx).methodAttrs.ToSlice())
+
+	// unknown methods get it from Begin
+	rpcCtx = h.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/evil.Scanner/Probe"})
+	assert.Equal(t, "", getRPCInfo(rpcCtx).methodType)
+
+	h.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now(), IsClientStream: true, IsServerStream: true})
+	assert.Equal(t, "bidi_streaming", getRPCInfo(rpcCtx).methodType)
+
+	// disabled by default
+	h, err = NewServerHandler()
+	assert.NoError(t, err)
+
+	rpcCtx = h.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Stream"})
+	h.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now(), IsServerStream: true})
+	assert.Equal(t, "", getRPCInfo(rpcCtx).methodType)
+}
+
+func TestGetConnAttributes(t *testing.T) {
+	tcpAttrs := getConnAttributes(&stats.ConnTagInfo{
+		LocalAddr:  &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080},
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 54321},
+	}, nil)
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			attribute.Key("net.sock.host.family").String("inet"),
+			attribute.Key("net.sock.peer.family").String("inet6"),
+		},
+		tcpAttrs.ToSlice(),
+	)
+
+	unixAttrs := getConnAttributes(&stats.ConnTagInfo{
+		LocalAddr:  &net.UnixAddr{Name: "/tmp/grpc.sock", Net: "unix"},
+		RemoteAddr: &net.UnixAddr{Name: "@", Net: "unix"},
+	}, nil)
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			attribute.Key("net.sock.host.family").String("unix"),
+			attribute.Key("net.sock.peer.family").String("unix"),
+		},
+		unixAttrs.ToSlice(),
+	)
+
+	emptyAttrs := getConnAttributes(&stats.ConnTagInfo{}, nil)
+	assert.Equal(t, 0, emptyAttrs.Len())
+}
+
+func TestNetworkAttributes(t *testing.T) {
+	server := &networkAttributes{peerIPv4PrefixLen: 24, peerIPv6PrefixLen: 64}
+	client := &networkAttributes{isClient: true, peerIPv4PrefixLen: 24, peerIPv6PrefixLen: 64}
+
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			attribute.Key("network.transport").String("tcp"),
+			attribute.Key("network.type").String("ipv4"),
+			attribute.Key("server.port").Int(8080),
+			attribute.Key("network.local.address").String("10.0.0.1"),
+			attribute.Key("network.peer.address").String("10.1.2.0"),
+		},
+		server.get(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}, &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 54321}),
+	)
+
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			attribute.Key("network.transport").String("tcp"),
+			attribute.Key("network.type").String("ipv6"),
+			attribute.Key("server.port").Int(443),
+			attribute.Key("network.local.address").String("2001:db8::1"),
+			attribute.Key("network.peer.address").String("2001:db8:1:2::"),
+		},
+		client.get(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 54321}, &net.TCPAddr{IP: net.ParseIP("2001:db8:1:2::5"), Port: 443}),
+	)
+
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			attribute.Key("network.transport").String("unix"),
+			attribute.Key("network.local.address").String("/tmp/grpc.sock"),
+		},
+		server.get(&net.UnixAddr{Name: "/tmp/grpc.sock", Net: "unix"}, &net.UnixAddr{Name: "@", Net: "unix"}),
+	)
+
+	// peer addresses are only recorded with a prefix length
+	assert.ElementsMatch(t,
+		[]attribute.KeyValue{
+			attribute.Key("network.transport").String("tcp"),
+			attribute.Key("network.type").String("ipv4"),
+			attribute.Key("server.port").Int(8080),
+			attribute.Key("network.local.address").String("10.0.0.1"),
+		},
+		(&networkAttributes{}).get(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}, &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 54321}),
+	)
+
+	assert.Empty(t, server.get(nil, nil))
+
+	// out of range prefix lengths are clamped to the length of the address
+	clamped := newNetworkAttributes(config{networkAttributes: true, networkPeerIPv4PrefixLen: 40, networkPeerIPv6PrefixLen: 200}, false)
+	assert.Equal(t, "10.1.2.3", clamped.peerAddress(net.ParseIP("10.1.2.3")))
+	assert.Equal(t, "2001:db8:1:2::5", clamped.peerAddress(net.ParseIP("2001:db8:1:2::5")))
+
+	negative := newNetworkAttributes(config{networkAttributes: true, networkPeerIPv4PrefixLen: -8, networkPeerIPv6PrefixLen: -8}, false)
+	assert.Empty(t, negative.peerAddress(net.ParseIP("10.1.2.3")))
+	assert.Empty(t, negative.peerAddress(net.ParseIP("2001:db8:1:2::5")))
+
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	handler, err := NewServerHandler(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithInstrumentLatency(true),
+		WithNetworkAttributes(true),
+		WithNetworkPeerAddress(24, 0),
+	)
+	assert.NoError(t, err)
+
+	// attributes of the connection are taken from TagConn
+	connCtx := handler.TagConn(ctx, &stats.ConnTagInfo{
+		LocalAddr:  &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080},
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 54321},
+	})
+	handler.HandleConn(connCtx, &stats.ConnBegin{})
+
+	rpcCtx := handler.TagRPC(connCtx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"})
+	handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now()})
+	handler.HandleRPC(rpcCtx, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+
+	// or from the headers when the transport doesn't tag connections
+	rpcCtx = handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Error"})
+	handler.HandleRPC(rpcCtx, &stats.InHeader{
+		LocalAddr:  &net.UnixAddr{Name: "/tmp/grpc.sock", Net: "unix"},
+		RemoteAddr: &net.UnixAddr{Name: "@", Net: "unix"},
+	})
+	handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now()})
+	handler.HandleRPC(rpcCtx, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	tcpAttrs := []attribute.KeyValue{
+		attribute.Key("network.transport").String("tcp"),
+		attribute.Key("network.type").String("ipv4"),
+		attribute.Key("server.port").Int(8080),
+		attribute.Key("network.local.address").String("10.0.0.1"),
+		attribute.Key("network.peer.address").String("10.1.2.0"),
+	}
+
+	assertMetric(t, rm.ScopeMetrics, append([]attribute.KeyValue{
+		attribute.Key("net.sock.host.family").String("inet"),
+		attribute.Key("net.sock.peer.family").String("inet"),
+	}, tcpAttrs...), metricdata.Metrics{Name: "rpc.server.connections.opened", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+
+	durations := map[string][]attribute.KeyValue{}
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.server.duration" {
+			continue
+		}
+
+		for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints { //nolint:forcetypeassert
+			method, _ := dp.Attributes.Value("rpc.method")
+			durations[method.AsString()] = dp.Attributes.ToSlice()
+		}
+	}
+
+	statusAttrs := []attribute.KeyValue{
+		attribute.Key("rpc.grpc.status").String("OK"),
+		attribute.Key("rpc.grpc.status_code").Int(0),
+		attribute.Key("rpc.service").String("testserver.TestsService"),
+		attribute.Key("rpc.system").String("grpc"),
+	}
+
+	assert.ElementsMatch(t, append(append([]attribute.KeyValue{attribute.Key("rpc.method").String("Ok")}, statusAttrs...), tcpAttrs...), durations["Ok"])
+	assert.ElementsMatch(t, append(append([]attribute.KeyValue{attribute.Key("rpc.method").String("Error")}, statusAttrs...),
+		attribute.Key("network.transport").String("unix"),
+		attribute.Key("network.local.address").String("/tmp/grpc.sock"),
+	), durations["Error"])
+}
+
+func TestCallerIdentity(t *testing.T) {
+	cert := func(uris ...string) *x509.Certificate {
+		c := &x509.Certificate{}
+
+		for _, u := range uris {
+			parsed, err := url.Parse(u)
+			assert.NoError(t, err)
+
+			c.URIs = append(c.URIs, parsed)
+		}
+
+		return c
+	}
+
+	billing := cert("https://billing.example.com", "spiffe://example.com/ns/prod/sa/billing")
+
+	assert.Equal(t, "spiffe://example.com/ns/prod/sa/billing", SPIFFEIdentity(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{billing}}}))
+	assert.Equal(t, "spiffe://example.com/ns/prod/sa/billing", SPIFFEIdentity(tls.ConnectionState{PeerCertificates: []*x509.Certificate{billing}}))
+	assert.Equal(t, "", SPIFFEIdentity(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert("https://billing.example.com")}}))
+	assert.Equal(t, "", SPIFFEIdentity(tls.ConnectionState{}))
+
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	handler, err := NewServerHandler(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithCallerIdentity(SPIFFEIdentity, 1),
+		WithTLSAttributes(true),
+	)
+	assert.NoError(t, err)
+
+	rpc := func(state *tls.ConnectionState) {
+		p := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 54321}}
+		if state != nil {
+			p.AuthInfo = credentials.TLSInfo{State: *state}
+		}
+
+		rpcCtx := handler.TagRPC(peer.NewContext(ctx, p), &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"})
+		handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now()})
+		handler.HandleRPC(rpcCtx, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+	}
+
+	tlsState := func(c *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, VerifiedChains: [][]*x509.Certificate{{c}}}
+	}
+
+	rpc(tlsState(billing))
+	rpc(tlsState(billing))
+	// identities beyond the limit are recorded as _OTHER
+	rpc(tlsState(cert("spiffe://example.com/ns/prod/sa/attacker")))
+	// callers without an identity only get the tls attributes
+	rpc(tlsState(cert()))
+	// plaintext callers get none
+	rpc(nil)
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	started := map[string]int64{}
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.server.started" {
+			continue
+		}
+
+		for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+			identity, _ := dp.Attributes.Value("rpc.caller.identity")
+			version, _ := dp.Attributes.Value("tls.protocol.version")
+			cipher, _ := dp.Attributes.Value("tls.cipher")
+			started[identity.AsString()+" "+version.AsString()+" "+cipher.AsString()] = dp.Value
+		}
+	}
+
+	assert.Equal(t, map[string]int64{
+		"spiffe://example.com/ns/prod/sa/billing 1.3 TLS_AES_128_GCM_SHA256": 2,
+		"_OTHER 1.3 TLS_AES_128_GCM_SHA256":                                  1,
+		" 1.3 TLS_AES_128_GCM_SHA256":                                        1,
+		"  ":                                                                 1,
+	}, started)
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.server.requests_per_rpc" {
+			continue
+		}
+
+		identities := 0
+
+		for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+			if _, ok := dp.Attributes.Value("rpc.caller.identity"); ok {
+				identities++
+			}
+		}
+
+		assert.Equal(t, 2, identities)
+	}
+
+	client, err := NewClientHandler(WithCallerIdentity(SPIFFEIdentity, 0), WithTLSAttributes(true))
+	assert.NoError(t, err)
+	assert.Nil(t, client.caller)
+}
+
+func TestCIDRZoneResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zones")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+# us-east-1
+10.1.0.0/16   us-east-1a
+10.1.128.0/17 us-east-1b
+2001:db8::/32 us-east-1c
+`), 0o600))
+
+	z, err := LoadCIDRZoneResolver(path)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "us-east-1a", z.Zone(net.ParseIP("10.1.0.1")))
+	// the most specific range wins
+	assert.Equal(t, "us-east-1b", z.Zone(net.ParseIP("10.1.200.1")))
+	assert.Equal(t, "us-east-1c", z.Zone(net.ParseIP("2001:db8::1")))
+	assert.Equal(t, "", z.Zone(net.ParseIP("192.168.0.1")))
+
+	_, err = NewCIDRZoneResolver(strings.NewReader("10.1.0.0/16"))
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = NewCIDRZoneResolver(strings.NewReader("\n10.1.0.0/33 us-east-1a"))
+	assert.ErrorContains(t, err, "line 2")
+
+	_, err = LoadCIDRZoneResolver(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestZoneTraffic(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	zones, err := NewCIDRZoneResolver(strings.NewReader("10.1.0.0/16 us-east-1a\n10.2.0.0/16 us-east-1b"))
+	assert.NoError(t, err)
+
+	handler, err := NewServerHandler(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithInstrumentLatency(true),
+		WithZoneResolver(zones),
+	)
+	assert.NoError(t, err)
+
+	rpc := func(peer string, sent int) {
+		rpcCtx := handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"})
+		handler.HandleRPC(rpcCtx, &stats.InHeader{
+			LocalAddr:  &net.TCPAddr{IP: net.ParseIP("10.1.0.1"), Port: 8080},
+			RemoteAddr: &net.TCPAddr{IP: net.ParseIP(peer), Port: 54321},
+		})
+		handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now()})
+		handler.HandleRPC(rpcCtx, &stats.InPayload{Length: 1, RecvTime: time.Now()})
+		handler.HandleRPC(rpcCtx, &stats.OutPayload{Length: sent, SentTime: time.Now()})
+		handler.HandleRPC(rpcCtx, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+	}
+
+	rpc("10.1.0.2", 10)
+	rpc("10.2.0.2", 100)
+	rpc("10.2.0.3", 1000)
+	rpc("192.168.0.1", 5)
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	values := func(name string) map[string]int64 {
+		v := map[string]int64{}
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != name {
+				continue
+			}
+
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+				assert.Equal(t, 2, dp.Attributes.Len())
+
+				local, _ := dp.Attributes.Value("local.zone")
+				peer, _ := dp.Attributes.Value("peer.zone")
+				v[local.AsString()+" -> "+peer.AsString()] = dp.Value
+			}
+		}
+
+		return v
+	}
+
+	assert.Equal(t, map[string]int64{
+		"us-east-1a -> us-east-1a": 10,
+		"us-east-1a -> us-east-1b": 1100,
+		"us-east-1a -> unknown":    5,
+	}, values("rpc.server.zone.sent_bytes"))
+	assert.Equal(t, map[string]int64{
+		"us-east-1a -> us-east-1a": 1,
+		"us-east-1a -> us-east-1b": 2,
+		"us-east-1a -> unknown":    1,
+	}, values("rpc.server.zone.received_bytes"))
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.server.duration" {
+			continue
+		}
+
+		for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints { //nolint:forcetypeassert
+			local, _ := dp.Attributes.Value("local.zone")
+			assert.Equal(t, "us-east-1a", local.AsString())
+		}
+
+		assert.Len(t, m.Data.(metricdata.Histogram[float64]).DataPoints, 3) //nolint:forcetypeassert
+	}
+}
+
+func TestFilters(t *testing.T) {
+	health := &stats.RPCTagInfo{FullMethodName: "/grpc.health.v1.Health/Check"}
+	products := &stats.RPCTagInfo{FullMethodName: "/product.Products/ListTags"}
+
+	assert.False(t, DenyMethods("/grpc.health.v1.Health/*")(health))
+	assert.True(t, DenyMethods("/grpc.health.v1.Health/*")(products))
+	assert.True(t, AllowMethods("/product.*/*", "[")(products))
+	assert.False(t, AllowMethods("[")(products))
+
+	reflection := regexp.MustCompile(`^/grpc\.(health|reflection)\.`)
+	assert.False(t, DenyMethodsRegexp(reflection)(health))
+	assert.True(t, DenyMethodsRegexp(reflection)(products))
+	assert.True(t, AllowMethodsRegexp(reflection)(health))
+	assert.False(t, AllowMethodsRegexp(reflection)(products))
 }
 
 func TestNewHandler(t *testing.T) {
@@ -76,11 +665,16 @@ func TestNewHandler(t *testing.T) {
 	assert.Nil(t, withDefaults.rpcResponseSize)
 	assert.NotNil(t, withDefaults.rpcRequestsPerRPC)
 	assert.NotNil(t, withDefaults.rpcResponsesPerRPC)
+	assert.NotNil(t, withDefaults.connOpened)
+	assert.NotNil(t, withDefaults.connClosed)
+	assert.NotNil(t, withDefaults.connActive)
+	assert.Nil(t, withDefaults.connDuration)
 
 	withConfigs, err := newHandler(true, []Option{
 		WithInstrumentLatency(true),
 		WithInstrumentationName("my_name"),
 		WithInstrumentSizes(true),
+		WithInstrumentConnectionDuration(true),
 		WithMeterProvider(noop.NewMeterProvider()),
 	})
 
@@ -90,14 +684,20 @@ func TestNewHandler(t *testing.T) {
 	assert.NotNil(t, withConfigs.rpcResponseSize)
 	assert.NotNil(t, withConfigs.rpcRequestsPerRPC)
 	assert.NotNil(t, withConfigs.rpcResponsesPerRPC)
+	assert.NotNil(t, withConfigs.connDuration)
+
+	withStable, err := newHandler(false, []Option{WithInstrumentLatency(true), WithSemconvMode(SemconvStable)})
+	assert.NoError(t, err)
+	assert.Nil(t, withStable.rpcDuration)
+	assert.NotNil(t, withStable.rpcCallDuration)
 }
 
-func newTestServer(t *testing.T, lis *bufconn.Listener) func() metricdata.ResourceMetrics {
+func newTestServer(t *testing.T, lis *bufconn.Listener, options ...Option) func() metricdata.ResourceMetrics {
 	t.Helper()
 
 	exp := &exporter{}
 	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)))
-	handler, err := NewServerHandler(WithMeterProvider(mp), WithInstrumentLatency(true), WithInstrumentSizes(true))
+	handler, err := NewServerHandler(append([]Option{WithMeterProvider(mp), WithInstrumentLatency(true), WithInstrumentSizes(true)}, options...)...)
 	assert.NoError(t, err)
 
 	s := grpc.NewServer(grpc.StatsHandler(handler))
@@ -121,14 +721,14 @@ func newTestServer(t *testing.T, lis *bufconn.Listener) func() metricdata.Resour
 	}
 }
 
-func newTestClient(t *testing.T, lis *bufconn.Listener) (testserver.TestsServiceClient, func() metricdata.ResourceMetrics) {
+func newTestClient(t *testing.T, lis *bufconn.Listener, options ...Option) (testserver.TestsServiceClient, func() metricdata.ResourceMetrics) {
 	t.Helper()
 
 	exp := &exporter{}
 	// xx, _ := stdoutmetric.New()
 	// mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)), sdkmetric.WithReader(sdkmetric.NewPeriodicReader(xx)))
 	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)))
-	handler, err := NewClientHandler(WithMeterProvider(mp), WithInstrumentLatency(true), WithInstrumentSizes(true))
+	handler, err := NewClientHandler(append([]Option{WithMeterProvider(mp), WithInstrumentLatency(true), WithInstrumentSizes(true)}, options...)...)
 	assert.NoError(t, err)
 
 	bufDialer := func(_ context.Context, address string) (net.Conn, error) {
@@ -177,8 +777,8 @@ func TestUnary(t *testing.T) {
 		IsMonotonic: true,
 		DataPoints:  []metricdata.DataPoint[int64]{{Value: 2}},
 	}})
-	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[int64]{
-		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 2}},
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 2}},
 	}})
 	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
 		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 2}},
@@ -197,8 +797,8 @@ func TestUnary(t *testing.T) {
 		IsMonotonic: true,
 		DataPoints:  []metricdata.DataPoint[int64]{{Value: 2}},
 	}})
-	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.duration", Unit: "ms", Data: metricdata.Histogram[int64]{
-		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 2}},
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 2}},
 	}})
 	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
 		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 2}},
@@ -236,8 +836,8 @@ func TestError(t *testing.T) {
 		IsMonotonic: true,
 		DataPoints:  []metricdata.DataPoint[int64]{{Value: 0}}, // zero out since errored
 	}})
-	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[int64]{
-		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
 	}})
 	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
 		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
@@ -256,8 +856,8 @@ func TestError(t *testing.T) {
 		IsMonotonic: true,
 		DataPoints:  []metricdata.DataPoint[int64]{{Value: 0}},
 	}})
-	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.duration", Unit: "ms", Data: metricdata.Histogram[int64]{
-		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
 	}})
 	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
 		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
@@ -304,8 +904,8 @@ func TestStream(t *testing.T) {
 		IsMonotonic: true,
 		DataPoints:  []metricdata.DataPoint[int64]{{Value: 10}},
 	}})
-	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[int64]{
-		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
 	}})
 	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
 		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
@@ -324,8 +924,8 @@ func TestStream(t *testing.T) {
 		IsMonotonic: true,
 		DataPoints:  []metricdata.DataPoint[int64]{{Value: 10}},
 	}})
-	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.duration", Unit: "ms", Data: metricdata.Histogram[int64]{
-		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
 	}})
 	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
 		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
@@ -335,13 +935,1440 @@ func TestStream(t *testing.T) {
 	}})
 }
 
-func assertMetric(t *testing.T, inMetrics []metricdata.ScopeMetrics, attrs []attribute.KeyValue, has metricdata.Metrics) {
-	t.Helper()
+func TestConnections(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
 
-	for _, sm := range inMetrics {
-		assert.Equal(t, DefaultInstrumentationName, sm.Scope.Name)
+	sMetrics := newTestServer(t, lis)
+	cli, cMetrics := newTestClient(t, lis, WithInstrumentConnectionDuration(true))
 
-		for _, m := range sm.Metrics {
+	_, err := cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	clientMetrics := cMetrics().ScopeMetrics
+
+	assertMetric(t, clientMetrics, nil, metricdata.Metrics{Name: "rpc.client.connections.opened", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+	assertMetric(t, clientMetrics, nil, metricdata.Metrics{Name: "rpc.client.connections.closed", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+	assertMetric(t, clientMetrics, nil, metricdata.Metrics{Name: "rpc.client.connections.active", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: false,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 0}},
+	}})
+	assertMetric(t, clientMetrics, nil, metricdata.Metrics{Name: "rpc.client.connection.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+
+	sMetrics()
+}
+
+func TestActiveRPCs(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithInstrumentActiveRPCsMax(true))
+	cli, cMetrics := newTestClient(t, lis)
+
+	_, err := cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	_, err = cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.method", Value: attribute.StringValue("Ok")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	serverMetrics := sMetrics().ScopeMetrics
+
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.started", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 2}},
+	}})
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.active_rpcs", Unit: "1", Data: metricdata.Sum[int64]{
+		DataPoints: []metricdata.DataPoint[int64]{{Value: 0}},
+	}})
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.active_rpcs.max", Unit: "1", Data: metricdata.Gauge[int64]{
+		DataPoints: []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+
+	clientMetrics := cMetrics().ScopeMetrics
+
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.started", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 2}},
+	}})
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.active_rpcs", Unit: "1", Data: metricdata.Sum[int64]{
+		DataPoints: []metricdata.DataPoint[int64]{{Value: 0}},
+	}})
+}
+
+func TestAttributesFunc(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	attributesFunc := func(_ context.Context, info *stats.RPCTagInfo, s *status.Status, end *stats.End) []attribute.KeyValue {
+		assert.Equal(t, "/testserver.TestsService/Error", info.FullMethodName)
+		assert.Equal(t, codes.NotFound, s.Code())
+		assert.Error(t, end.Error)
+
+		return []attribute.KeyValue{
+			attribute.Key("tenant").String("acme"),
+			semconv.RPCMethodKey.String("overridden"),
+		}
+	}
+
+	sMetrics := newTestServer(t, lis, WithAttributesFunc(attributesFunc))
+	cli, cMetrics := newTestClient(t, lis)
+
+	_, err := cli.Error(ctx, &testserver.Empty{})
+	assert.Error(t, err)
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("NotFound")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.NotFound))},
+		{Key: "rpc.method", Value: attribute.StringValue("Error")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+		{Key: "tenant", Value: attribute.StringValue("acme")},
+	}
+
+	serverMetrics := sMetrics().ScopeMetrics
+
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.requests_per_rpc", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+
+	cMetrics()
+}
+
+func TestLabeler(t *testing.T) {
+	_, ok := LabelerFromContext(context.Background())
+	assert.False(t, ok)
+
+	exp := &exporter{}
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)))
+	h, err := NewServerHandler(WithMeterProvider(mp))
+	assert.NoError(t, err)
+
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Stream"})
+	h.HandleRPC(ctx, &stats.Begin{BeginTime: time.Now()})
+
+	labeler, ok := LabelerFromContext(ctx)
+	assert.True(t, ok)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		labeler.Add(attribute.Key("cache").String("hit"))
+	}()
+	labeler.Add(attribute.Key("region").String("eu-west"))
+	<-done
+
+	h.HandleRPC(ctx, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+	mp.ForceFlush(context.Background())
+
+	attrs := []attribute.KeyValue{
+		{Key: "cache", Value: attribute.StringValue("hit")},
+		{Key: "region", Value: attribute.StringValue("eu-west")},
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+		{Key: "rpc.method", Value: attribute.StringValue("Stream")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	assertMetric(t, exp.Read().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.server.requests_per_rpc", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 0}},
+	}})
+}
+
+func TestMetadataAttributes(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	options := []Option{WithMetadataAttributes("x-tenant", "X-Client-Name"), WithMetadataMaxLength(4), WithMetadataFallback("unknown")}
+
+	sMetrics := newTestServer(t, lis, options...)
+	cli, cMetrics := newTestClient(t, lis, options...)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme-corp")
+
+	_, err := cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.request.metadata.x-client-name", Value: attribute.StringSliceValue([]string{"unknown"})},
+		{Key: "rpc.grpc.request.metadata.x-tenant", Value: attribute.StringSliceValue([]string{"acme"})},
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+		{Key: "rpc.method", Value: attribute.StringValue("Ok")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	assertMetric(t, sMetrics().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.server.requests_per_rpc", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+	assertMetric(t, cMetrics().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.client.requests_per_rpc", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+}
+
+func TestFilteredRPC(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithFilter(DenyMethods("/testserver.TestsService/Error")))
+	cli, cMetrics := newTestClient(t, lis)
+
+	_, err := cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	_, err = cli.Error(ctx, &testserver.Empty{})
+	assert.Error(t, err)
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+		{Key: "rpc.method", Value: attribute.StringValue("Ok")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	assertMetric(t, sMetrics().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.server.requests_per_rpc", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+
+	// the filter of the server doesn't apply to the client
+	methods := []string{}
+
+	for _, m := range cMetrics().ScopeMetrics[0].Metrics {
+		if m.Name == "rpc.client.requests_per_rpc" {
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+				v, _ := dp.Attributes.Value("rpc.method")
+				methods = append(methods, v.AsString())
+			}
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"Ok", "Error"}, methods)
+}
+
+// relayServer calls the downstream server from its Ok method.
+type relayServer struct {
+	testserver.Server
+	downstream testserver.TestsServiceClient
+}
+
+func (s *relayServer) Ok(ctx context.Context, req *testserver.Empty) (*testserver.NonEmpty, error) {
+	return s.downstream.Ok(ctx, req)
+}
+
+func TestFilteredNestedRPC(t *testing.T) {
+	ctx := context.Background()
+	downLis, upLis := bufconn.Listen(1024*1024), bufconn.Listen(1024*1024)
+
+	newTestServer(t, downLis)
+	downstream, downMetrics := newTestClient(t, downLis, WithFilter(DenyMethods("/testserver.TestsService/Ok")))
+
+	reader := sdkmetric.NewManualReader()
+	handler, err := NewServerHandler(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))))
+	assert.NoError(t, err)
+
+	s := grpc.NewServer(grpc.StatsHandler(handler))
+	testserver.RegisterTestsServiceServer(s, &relayServer{downstream: downstream})
+
+	go func() { assert.NoError(t, s.Serve(upLis)) }()
+
+	cli, _ := newTestClient(t, upLis)
+
+	_, err = cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	s.GracefulStop()
+
+	// the filtered client call is not recorded on the server rpc it's made from
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name == "rpc.server.requests_per_rpc" || m.Name == "rpc.server.responses_per_rpc" {
+			dps := m.Data.(metricdata.Sum[int64]).DataPoints //nolint:forcetypeassert
+			assert.Len(t, dps, 1)
+			assert.Equal(t, int64(1), dps[0].Value, m.Name)
+
+			v, _ := dps[0].Attributes.Value("rpc.method")
+			assert.Equal(t, "Ok", v.AsString())
+		}
+	}
+
+	// nor by the client
+	for _, sm := range downMetrics().ScopeMetrics {
+		for _, m := range sm.Metrics {
+			assert.False(t, strings.HasPrefix(m.Name, "rpc.client.") && !strings.HasPrefix(m.Name, "rpc.client.connection"), m.Name)
+		}
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	// served by the HTTP/2 transport of gRPC which responds with trailers only to errors, otherwise they are not retried.
+	s := grpc.NewServer()
+	testserver.RegisterTestsServiceServer(s, &testserver.Server{})
+
+	go func() { assert.NoError(t, s.Serve(lis)) }()
+
+	exp := &exporter{}
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)))
+	handler, err := NewClientHandler(WithMeterProvider(mp), WithInstrumentLatency(true), WithSemconvMode(SemconvStable), WithInstrumentAttempts(true))
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial("",
+		grpc.WithStatsHandler(handler),
+		grpc.WithUnaryInterceptor(handler.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(handler.StreamClientInterceptor()),
+		grpc.WithContextDialer(func(_ context.Context, address string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithBlock(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// Error is retried until it runs out of attempts
+		grpc.WithDefaultServiceConfig(`{"methodConfig": [{
+			"name": [{"service": "testserver.TestsService", "method": "Error"}],
+			"retryPolicy": {"maxAttempts": 3, "initialBackoff": "0.001s", "maxBackoff": "0.001s", "backoffMultiplier": 1, "retryableStatusCodes": ["NOT_FOUND"]}
+		}]}`),
+	)
+	assert.NoError(t, err)
+
+	cli := testserver.NewTestsServiceClient(conn)
+
+	_, err = cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	_, err = cli.Error(ctx, &testserver.Empty{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	res, err := cli.Stream(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	for {
+		if _, err := res.Recv(); err != nil {
+			assert.ErrorIs(t, io.EOF, err)
+
+			break
+		}
+	}
+
+	assert.NoError(t, conn.Close())
+	s.Stop()
+	assert.NoError(t, mp.ForceFlush(ctx))
+
+	counts := func(name string) map[string]uint64 {
+		c := map[string]uint64{}
+
+		for _, m := range exp.Read().ScopeMetrics[0].Metrics {
+			if m.Name != name {
+				continue
+			}
+
+			switch d := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, dp := range d.DataPoints {
+					method, _ := dp.Attributes.Value("rpc.method")
+					c[method.AsString()] += dp.Count
+				}
+			case metricdata.Histogram[int64]:
+				for _, dp := range d.DataPoints {
+					method, _ := dp.Attributes.Value("rpc.method")
+					c[method.AsString()] += uint64(dp.Sum)
+				}
+			case metricdata.Sum[int64]:
+				for _, dp := range d.DataPoints {
+					method, _ := dp.Attributes.Value("rpc.method")
+					c[method.AsString()] += uint64(dp.Value)
+				}
+			}
+		}
+
+		return c
+	}
+
+	assert.Equal(t, map[string]uint64{"Ok": 1, "Error": 3, "Stream": 1}, counts("rpc.client.attempt.started"))
+	assert.Equal(t, map[string]uint64{"Ok": 1, "Error": 3, "Stream": 1}, counts("rpc.client.attempt.duration"))
+	// calls are recorded once, not once per attempt
+	assert.Equal(t, map[string]uint64{"Ok": 1, "Error": 1, "Stream": 1}, counts("rpc.client.call.duration"))
+	assert.Equal(t, map[string]uint64{"Ok": 1, "Error": 3, "Stream": 1}, counts("rpc.client.attempts_per_call"))
+
+	for _, m := range exp.Read().ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.client.call.duration" {
+			continue
+		}
+
+		for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints { //nolint:forcetypeassert
+			if method, _ := dp.Attributes.Value("rpc.method"); method.AsString() == "Error" {
+				errorType, _ := dp.Attributes.Value("error.type")
+				assert.Equal(t, "NotFound", errorType.AsString())
+			}
+		}
+	}
+}
+
+func TestAbandonedStreamCall(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	s := grpc.NewServer()
+	testserver.RegisterTestsServiceServer(s, &testserver.Server{})
+
+	go func() { assert.NoError(t, s.Serve(lis)) }()
+
+	defer s.Stop()
+
+	reader := sdkmetric.NewManualReader()
+	handler, err := NewClientHandler(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))), WithInstrumentAttempts(true))
+	assert.NoError(t, err)
+
+	conn, err := grpc.Dial("",
+		grpc.WithStatsHandler(handler),
+		grpc.WithStreamInterceptor(handler.StreamClientInterceptor()),
+		grpc.WithContextDialer(func(_ context.Context, address string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	// the stream is dropped after its first message without being drained
+	callCtx, cancel := context.WithCancel(ctx)
+
+	res, err := testserver.NewTestsServiceClient(conn).Stream(callCtx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	_, err = res.Recv()
+	assert.NoError(t, err)
+
+	cancel()
+
+	callErrorType := func() string {
+		var rm metricdata.ResourceMetrics
+
+		assert.NoError(t, reader.Collect(ctx, &rm))
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != "rpc.client.call.duration" {
+				continue
+			}
+
+			for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints { //nolint:forcetypeassert
+				errorType, _ := dp.Attributes.Value("error.type")
+
+				return errorType.AsString()
+			}
+		}
+
+		return ""
+	}
+
+	assert.Eventually(t, func() bool { return callErrorType() == "Canceled" }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTransparentRetryAttempts(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	handler, err := NewClientHandler(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithInstrumentLatency(true),
+		WithSemconvMode(SemconvStable),
+		WithInstrumentAttempts(true),
+	)
+	assert.NoError(t, err)
+
+	ci := &callInfo{}
+	callCtx := setCallInfo(ctx, ci)
+
+	for _, transparentRetry := range []bool{false, true} {
+		rpcCtx := handler.TagRPC(callCtx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"})
+		handler.HandleRPC(rpcCtx, &stats.Begin{Client: true, BeginTime: time.Now(), IsTransparentRetryAttempt: transparentRetry})
+		handler.HandleRPC(rpcCtx, &stats.End{Client: true, BeginTime: time.Now(), EndTime: time.Now()})
+	}
+
+	assert.Equal(t, int64(2), ci.attempts)
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch m.Name {
+		case "rpc.client.attempt.started":
+			values := map[bool]int64{}
+
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+				transparentRetry, _ := dp.Attributes.Value("rpc.grpc.transparent_retry")
+				values[transparentRetry.AsBool()] = dp.Value
+			}
+
+			assert.Equal(t, map[bool]int64{false: 1, true: 1}, values)
+		case "rpc.client.call.duration":
+			// the interceptor owns the call duration of attempts tagged with a call
+			assert.Empty(t, m.Data.(metricdata.Histogram[float64]).DataPoints) //nolint:forcetypeassert
+		}
+	}
+
+	server, err := NewServerHandler(WithInstrumentAttempts(true))
+	assert.NoError(t, err)
+	assert.Nil(t, server.attempts)
+}
+
+func TestRetryAttempts(t *testing.T) {
+	assert.Equal(t, 0, getRetryAttempt(nil))
+	assert.Equal(t, 0, getRetryAttempt(metadata.Pairs("grpc-previous-rpc-attempts", "invalid")))
+	assert.Equal(t, 0, getRetryAttempt(metadata.Pairs("grpc-previous-rpc-attempts", "-1")))
+	assert.Equal(t, 2, getRetryAttempt(metadata.Pairs("grpc-previous-rpc-attempts", "2")))
+
+	assert.Equal(t, "0", retryAttemptValue(0))
+	assert.Equal(t, "2", retryAttemptValue(2))
+	assert.Equal(t, "3+", retryAttemptValue(3))
+	assert.Equal(t, "3+", retryAttemptValue(10))
+
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+	reader := sdkmetric.NewManualReader()
+
+	handler, err := NewServerHandler(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))), WithRetryAttempts(true))
+	assert.NoError(t, err)
+
+	// served by the HTTP/2 transport of gRPC which responds with trailers only to errors, otherwise they are not retried.
+	s := grpc.NewServer(grpc.StatsHandler(handler))
+	testserver.RegisterTestsServiceServer(s, &testserver.Server{})
+
+	go func() { assert.NoError(t, s.Serve(lis)) }()
+
+	conn, err := grpc.Dial("",
+		grpc.WithContextDialer(func(_ context.Context, address string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithBlock(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"methodConfig": [{
+			"name": [{"service": "testserver.TestsService", "method": "Error"}],
+			"retryPolicy": {"maxAttempts": 3, "initialBackoff": "0.001s", "maxBackoff": "0.001s", "backoffMultiplier": 1, "retryableStatusCodes": ["NOT_FOUND"]}
+		}]}`),
+	)
+	assert.NoError(t, err)
+
+	cli := testserver.NewTestsServiceClient(conn)
+
+	_, err = cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	_, err = cli.Error(ctx, &testserver.Empty{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.NoError(t, conn.Close())
+	s.Stop()
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	values := func(name string) map[string]int64 {
+		v := map[string]int64{}
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != name {
+				continue
+			}
+
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+				method, _ := dp.Attributes.Value("rpc.method")
+				attempt, _ := dp.Attributes.Value("rpc.grpc.retry_attempt")
+				v[method.AsString()+" "+attempt.AsString()] = dp.Value
+			}
+		}
+
+		return v
+	}
+
+	assert.Equal(t, map[string]int64{"Ok 0": 1, "Error 0": 1, "Error 1": 1, "Error 2": 1}, values("rpc.server.started"))
+	assert.Equal(t, map[string]int64{"Error 1": 1, "Error 2": 1}, values("rpc.server.retried_requests"))
+
+	client, err := NewClientHandler(WithRetryAttempts(true))
+	assert.NoError(t, err)
+	assert.Nil(t, client.retriedRequests)
+}
+
+func TestErrorOrigin(t *testing.T) {
+	ctx := context.Background()
+
+	origin := func(isClient bool, rpc func(h *Handler)) string {
+		t.Helper()
+
+		reader := sdkmetric.NewManualReader()
+		options := []Option{WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))), WithInstrumentLatency(true), WithErrorOrigin(true)}
+
+		h, err := NewServerHandler(options...)
+		if isClient {
+			h, err = NewClientHandler(options...)
+		}
+
+		assert.NoError(t, err)
+
+		rpc(h)
+
+		var rm metricdata.ResourceMetrics
+
+		assert.NoError(t, reader.Collect(ctx, &rm))
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if strings.HasSuffix(m.Name, ".duration") {
+				dps := m.Data.(metricdata.Histogram[float64]).DataPoints //nolint:forcetypeassert
+				assert.Len(t, dps, 1)
+
+				v, _ := dps[0].Attributes.Value("error.origin")
+
+				return v.AsString()
+			}
+		}
+
+		return ""
+	}
+
+	end := func(h *Handler, rpcCtx context.Context, code codes.Code, trailer stats.RPCStats) {
+		h.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now()})
+
+		if trailer != nil {
+			h.HandleRPC(rpcCtx, trailer)
+		}
+
+		h.HandleRPC(rpcCtx, &stats.End{BeginTime: time.Now(), EndTime: time.Now(), Error: status.Error(code, "")})
+	}
+
+	info := &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"}
+
+	// clients tell their own context errors apart by the trailers sent by servers
+	assert.Equal(t, "local", origin(true, func(h *Handler) {
+		end(h, h.TagRPC(ctx, info), codes.DeadlineExceeded, nil)
+	}))
+	assert.Equal(t, "remote", origin(true, func(h *Handler) {
+		end(h, h.TagRPC(ctx, info), codes.Canceled, &stats.InTrailer{})
+	}))
+
+	// servers blame the caller once its deadline is over
+	assert.Equal(t, "remote", origin(false, func(h *Handler) {
+		rpcCtx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+		defer cancel()
+
+		end(h, h.TagRPC(rpcCtx, info), codes.DeadlineExceeded, &stats.OutTrailer{})
+	}))
+	assert.Equal(t, "propagated", origin(false, func(h *Handler) {
+		rpcCtx, cancel := context.WithDeadline(ctx, time.Now().Add(time.Hour))
+		defer cancel()
+
+		end(h, h.TagRPC(rpcCtx, info), codes.DeadlineExceeded, &stats.OutTrailer{})
+	}))
+
+	// or when it's canceled before the status is sent
+	assert.Equal(t, "remote", origin(false, func(h *Handler) {
+		end(h, h.TagRPC(ctx, info), codes.Canceled, nil)
+	}))
+	assert.Equal(t, "propagated", origin(false, func(h *Handler) {
+		end(h, h.TagRPC(ctx, info), codes.Canceled, &stats.OutTrailer{})
+	}))
+
+	// other codes have no origin
+	assert.Equal(t, "", origin(false, func(h *Handler) {
+		end(h, h.TagRPC(ctx, info), codes.NotFound, &stats.OutTrailer{})
+	}))
+}
+
+type validationError struct{ field string }
+
+func (e *validationError) Error() string { return "invalid " + e.field }
+
+func TestErrorClassifier(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	classifier := func(err error) (*status.Status, string) {
+		var vErr *validationError
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return status.New(codes.Unavailable, err.Error()), "eof"
+		case errors.As(err, &vErr):
+			return nil, "validation"
+		case errors.Is(err, io.ErrShortWrite):
+			return status.New(codes.OK, ""), "ignored"
+		}
+
+		return DefaultErrorClassifier(err)
+	}
+
+	h, err := NewServerHandler(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithInstrumentLatency(true),
+		WithErrorClassifier(classifier, 3),
+	)
+	assert.NoError(t, err)
+
+	info := &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"}
+	for _, rpcErr := range []error{
+		fmt.Errorf("reading body: %w", io.EOF),
+		&validationError{field: "name"},
+		context.DeadlineExceeded,
+		status.Error(codes.NotFound, ""),
+		io.ErrShortWrite,
+		nil,
+	} {
+		rpcCtx := h.TagRPC(ctx, info)
+		h.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now()})
+		h.HandleRPC(rpcCtx, &stats.End{BeginTime: time.Now(), EndTime: time.Now(), Error: rpcErr})
+	}
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	// error.type is recorded in legacy mode too, categories over the limit are recorded as _OTHER.
+	got := map[string]string{}
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.server.duration" {
+			continue
+		}
+
+		for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints { //nolint:forcetypeassert
+			code, _ := dp.Attributes.Value("rpc.grpc.status")
+			errorType, _ := dp.Attributes.Value("error.type")
+			got[code.AsString()] += errorType.AsString()
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"Unavailable":      "eof",
+		"Internal":         "validation",
+		"DeadlineExceeded": "DeadlineExceeded",
+		"NotFound":         "_OTHER",
+		"OK":               "",
+	}, got)
+}
+
+func TestDeadlines(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithInstrumentDeadlines(true))
+	cli, cMetrics := newTestClient(t, lis, WithInstrumentDeadlines(true))
+
+	deadlineCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := cli.Ok(deadlineCtx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	_, err = cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	for side, rm := range map[string]metricdata.ResourceMetrics{"server": sMetrics(), "client": cMetrics()} {
+		found := 0
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			switch m.Name {
+			case "rpc." + side + ".deadline.remaining":
+				found++
+
+				assert.Equal(t, "ms", m.Unit)
+
+				dps := m.Data.(metricdata.Histogram[float64]).DataPoints //nolint:forcetypeassert
+				assert.Len(t, dps, 1, side)
+				assert.Equal(t, uint64(1), dps[0].Count, side)
+				assert.InDelta(t, 9500, dps[0].Sum, 500, side)
+			case "rpc." + side + ".deadline.consumed":
+				found++
+
+				dps := m.Data.(metricdata.Histogram[float64]).DataPoints //nolint:forcetypeassert
+				assert.Len(t, dps, 1, side)
+				assert.Equal(t, uint64(1), dps[0].Count, side)
+				assert.Less(t, dps[0].Sum, 1.0, side)
+			case "rpc." + side + ".deadline.missing":
+				found++
+
+				dps := m.Data.(metricdata.Sum[int64]).DataPoints //nolint:forcetypeassert
+				assert.Len(t, dps, 1, side)
+				assert.Equal(t, int64(1), dps[0].Value, side)
+			}
+		}
+
+		assert.Equal(t, 3, found, side)
+	}
+}
+
+func TestSemconvStable(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithSemconvMode(SemconvStable))
+	cli, cMetrics := newTestClient(t, lis, WithSemconvMode(SemconvStable))
+
+	_, err := cli.Error(ctx, &testserver.Empty{})
+	assert.Error(t, err)
+
+	attrs := []attribute.KeyValue{
+		{Key: "error.type", Value: attribute.StringValue("NotFound")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.NotFound))},
+		{Key: "rpc.method", Value: attribute.StringValue("Error")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	assertMetric(t, sMetrics().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.server.call.duration", Unit: "s", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+
+	// clients only know the resolved backend address which is not recorded as server.address
+	assertMetric(t, cMetrics().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.client.call.duration", Unit: "s", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+}
+
+func TestSemconvDuplicate(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithSemconvMode(SemconvDuplicate))
+	cli, cMetrics := newTestClient(t, lis)
+
+	_, err := cli.Error(ctx, &testserver.Empty{})
+	assert.Error(t, err)
+
+	methodAttrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.NotFound))},
+		{Key: "rpc.method", Value: attribute.StringValue("Error")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+	legacyAttrs := append([]attribute.KeyValue{{Key: "rpc.grpc.status", Value: attribute.StringValue("NotFound")}}, methodAttrs...)
+	stableAttrs := append([]attribute.KeyValue{{Key: "error.type", Value: attribute.StringValue("NotFound")}}, methodAttrs...)
+
+	serverMetrics := sMetrics().ScopeMetrics
+
+	// legacy instruments keep their series while the stable call duration follows the current conventions.
+	assertMetric(t, serverMetrics, legacyAttrs, metricdata.Metrics{Name: "rpc.server.duration", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+	assertMetric(t, serverMetrics, legacyAttrs, metricdata.Metrics{Name: "rpc.server.requests_per_rpc", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+	assertMetric(t, serverMetrics, legacyAttrs, metricdata.Metrics{Name: "rpc.server.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
+	}})
+	assertMetric(t, serverMetrics, stableAttrs, metricdata.Metrics{Name: "rpc.server.call.duration", Unit: "s", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+
+	cMetrics()
+}
+
+func TestMessagesPerRPCMode(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithMessagesPerRPCMode(MessagesPerRPCBoth))
+	cli, cMetrics := newTestClient(t, lis, WithMessagesPerRPCMode(MessagesPerRPCHistogram))
+
+	res, err := cli.Stream(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	for {
+		_, err := res.Recv()
+		if err != nil {
+			assert.ErrorIs(t, io.EOF, err)
+
+			break
+		}
+	}
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+		{Key: "rpc.method", Value: attribute.StringValue("Stream")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	serverMetrics := sMetrics().ScopeMetrics
+
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.responses_per_rpc", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 10}},
+	}})
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.responses_per_rpc.histogram", Unit: "1", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1, Sum: 10}},
+	}})
+
+	assertMetric(t, cMetrics().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.client.responses_per_rpc", Unit: "1", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1, Sum: 10}},
+	}})
+}
+
+func TestBucketBoundaries(t *testing.T) {
+	exp := &exporter{}
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)))
+	h, err := NewServerHandler(
+		WithMeterProvider(mp),
+		WithInstrumentLatency(true),
+		WithSemconvMode(SemconvStable),
+		WithMessagesPerRPCMode(MessagesPerRPCBoth),
+	)
+	assert.NoError(t, err)
+
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"})
+	h.HandleRPC(ctx, &stats.Begin{BeginTime: time.Now()})
+	h.HandleRPC(ctx, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+	mp.ForceFlush(context.Background())
+
+	found := 0
+
+	for _, sm := range exp.Read().ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch d := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				if m.Name == "rpc.server.call.duration" {
+					assert.Equal(t, callDurationBuckets, d.DataPoints[0].Bounds)
+					found++
+				}
+			case metricdata.Histogram[int64]:
+				assert.NotEqual(t, "rpc.server.requests_per_rpc", m.Name, "counter must not be turned into histogram")
+
+				if m.Name == "rpc.server.requests_per_rpc.histogram" {
+					assert.Equal(t, messagesPerRPCBuckets, d.DataPoints[0].Bounds)
+					found++
+				}
+			}
+		}
+	}
+
+	assert.Equal(t, 2, found)
+}
+
+func TestPerMessageSizes(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithPerMessageSizes(true))
+	cli, cMetrics := newTestClient(t, lis, WithPerMessageSizes(true))
+
+	res, err := cli.Stream(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	for {
+		_, err := res.Recv()
+		if err != nil {
+			assert.ErrorIs(t, io.EOF, err)
+
+			break
+		}
+	}
+
+	methodAttrs := []attribute.KeyValue{
+		{Key: "rpc.method", Value: attribute.StringValue("Stream")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+	attrs := append([]attribute.KeyValue{
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+	}, methodAttrs...)
+
+	serverMetrics := sMetrics().ScopeMetrics
+
+	assertMetric(t, serverMetrics, methodAttrs, metricdata.Metrics{Name: "rpc.server.request.size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1}},
+	}})
+	assertMetric(t, serverMetrics, methodAttrs, metricdata.Metrics{Name: "rpc.server.response.size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 10, Sum: 18}},
+	}})
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.response.total_size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1, Sum: 18}},
+	}})
+
+	clientMetrics := cMetrics().ScopeMetrics
+
+	assertMetric(t, clientMetrics, methodAttrs, metricdata.Metrics{Name: "rpc.client.response.size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 10, Sum: 18}},
+	}})
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.response.total_size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1, Sum: 18}},
+	}})
+}
+
+func TestWireSizes(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithInstrumentWireSizes(true))
+	cli, cMetrics := newTestClient(t, lis, WithInstrumentWireSizes(true))
+
+	_, err := cli.Ok(ctx, &testserver.Empty{}, grpc.UseCompressor(gzip.Name))
+	assert.NoError(t, err)
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.encoding", Value: attribute.StringValue("gzip")},
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+		{Key: "rpc.method", Value: attribute.StringValue("Ok")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	// request is empty which is 20 bytes once gzipped, and 5 bytes of message framing.
+	assertMetric(t, sMetrics().ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.server.request.wire_size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1, Sum: 25}},
+	}})
+
+	clientMetrics := cMetrics().ScopeMetrics
+
+	// response is 2 bytes which is 27 bytes once gzipped, and 5 bytes of message framing.
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.response.wire_size", Unit: "By", Data: metricdata.Histogram[int64]{
+		DataPoints: []metricdata.HistogramDataPoint[int64]{{Count: 1, Sum: 32}},
+	}})
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.response.compression_ratio", Unit: "1", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1, Sum: 2.0 / 27}},
+	}})
+}
+
+func TestMetadataSizes(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis)
+	cli, cMetrics := newTestClient(t, lis, WithInstrumentMetadataSizes(true), WithMetadataSizeThreshold(1024))
+
+	_, err := cli.Ok(metadata.AppendToOutgoingContext(ctx, "authorization", strings.Repeat("x", 2048)), &testserver.Empty{})
+	assert.NoError(t, err)
+
+	_, err = cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	sMetrics()
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.metadata.direction", Value: attribute.StringValue("sent")},
+		{Key: "rpc.grpc.metadata.kind", Value: attribute.StringValue("header")},
+		{Key: "rpc.method", Value: attribute.StringValue("Ok")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	clientMetrics := cMetrics().ScopeMetrics
+
+	// received headers and trailers are also recorded, only check sent headers which are known upfront.
+	set := attribute.NewSet(attrs...)
+	found := false
+
+	for _, m := range clientMetrics[0].Metrics {
+		if m.Name != "rpc.client.metadata.keys" {
+			continue
+		}
+
+		for _, dp := range m.Data.(metricdata.Histogram[int64]).DataPoints { //nolint:forcetypeassert
+			if dp.Attributes.Equals(&set) {
+				found = true
+
+				// authorization and user-agent on the first rpc, only user-agent on the second one.
+				assert.Equal(t, uint64(2), dp.Count)
+				assert.Equal(t, int64(3), dp.Sum)
+			}
+		}
+	}
+
+	assert.True(t, found, "missing sent header keys")
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.metadata.oversized", Unit: "1", Data: metricdata.Sum[int64]{
+		IsMonotonic: true,
+		DataPoints:  []metricdata.DataPoint[int64]{{Value: 1}},
+	}})
+}
+
+func TestMetadataSize(t *testing.T) {
+	md := metadata.Pairs("a", "bc", "a", "d", "efg", "")
+
+	assert.Equal(t, 1+2+32+1+1+32+3+0+32, metadataSize(md))
+	assert.Equal(t, 0, metadataSize(nil))
+}
+
+func TestLatencyPhases(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithInstrumentLatencyPhases(true))
+	cli, cMetrics := newTestClient(t, lis, WithInstrumentLatencyPhases(true))
+
+	res, err := cli.Stream(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	for {
+		_, err := res.Recv()
+		if err != nil {
+			assert.ErrorIs(t, io.EOF, err)
+
+			break
+		}
+	}
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+		{Key: "rpc.method", Value: attribute.StringValue("Stream")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	serverMetrics := sMetrics().ScopeMetrics
+
+	for _, name := range []string{"rpc.server.time_to_first_response", "rpc.server.trailer_latency"} {
+		assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: name, Unit: "ms", Data: metricdata.Histogram[float64]{
+			DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+		}})
+	}
+
+	clientMetrics := cMetrics().ScopeMetrics
+
+	for _, name := range []string{"rpc.client.time_to_first_header", "rpc.client.time_to_first_response", "rpc.client.trailer_latency"} {
+		assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: name, Unit: "ms", Data: metricdata.Histogram[float64]{
+			DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+		}})
+	}
+}
+
+func TestStreamCadence(t *testing.T) {
+	ctx := context.Background()
+	lis := bufconn.Listen(1024 * 1024)
+
+	sMetrics := newTestServer(t, lis, WithInstrumentStreamCadence(true))
+	cli, cMetrics := newTestClient(t, lis, WithInstrumentStreamCadence(true))
+
+	// unary rpcs are not instrumented
+	_, err := cli.Ok(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	res, err := cli.Stream(ctx, &testserver.Empty{})
+	assert.NoError(t, err)
+
+	for {
+		_, err := res.Recv()
+		if err != nil {
+			assert.ErrorIs(t, io.EOF, err)
+
+			break
+		}
+	}
+
+	methodAttrs := []attribute.KeyValue{
+		{Key: "rpc.method", Value: attribute.StringValue("Stream")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+	attrs := append([]attribute.KeyValue{
+		{Key: "rpc.grpc.status", Value: attribute.StringValue("OK")},
+		{Key: "rpc.grpc.status_code", Value: attribute.IntValue(int(codes.OK))},
+	}, methodAttrs...)
+
+	serverMetrics := sMetrics().ScopeMetrics
+
+	assertMetric(t, serverMetrics, methodAttrs, metricdata.Metrics{Name: "rpc.server.stream.inter_send_time", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 9}},
+	}})
+	assertMetric(t, serverMetrics, attrs, metricdata.Metrics{Name: "rpc.server.stream.max_idle_time", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+
+	clientMetrics := cMetrics().ScopeMetrics
+
+	assertMetric(t, clientMetrics, methodAttrs, metricdata.Metrics{Name: "rpc.client.stream.inter_arrival_time", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 9}},
+	}})
+	assertMetric(t, clientMetrics, attrs, metricdata.Metrics{Name: "rpc.client.stream.max_idle_time", Unit: "ms", Data: metricdata.Histogram[float64]{
+		DataPoints: []metricdata.HistogramDataPoint[float64]{{Count: 1}},
+	}})
+}
+
+func TestUpdateStreamIdle(t *testing.T) {
+	ri := &rpcInfo{streamLastMessageTime: 100}
+
+	ri.updateStreamIdle(150)
+	ri.updateStreamIdle(400)
+	ri.updateStreamIdle(300) // out of order message does not move the last activity back
+	ri.updateStreamIdle(450)
+
+	assert.Equal(t, int64(250), ri.streamMaxIdle)
+	assert.Equal(t, int64(450), ri.streamLastMessageTime)
+}
+
+func TestInterimReporting(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	handler, err := NewServerHandler(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))), WithInterimReporting(0))
+	assert.NoError(t, err)
+
+	defer func() { assert.NoError(t, handler.Close()) }()
+
+	attrs := []attribute.KeyValue{
+		{Key: "rpc.method", Value: attribute.StringValue("Stream")},
+		{Key: "rpc.service", Value: attribute.StringValue("testserver.TestsService")},
+		{Key: "rpc.system", Value: attribute.StringValue("grpc")},
+	}
+
+	collect := func(responses, responseBytes int64) {
+		t.Helper()
+
+		var rm metricdata.ResourceMetrics
+
+		assert.NoError(t, reader.Collect(ctx, &rm))
+
+		assertMetric(t, rm.ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.server.response.messages", Unit: "1", Data: metricdata.Sum[int64]{
+			IsMonotonic: true,
+			DataPoints:  []metricdata.DataPoint[int64]{{Value: responses}},
+		}})
+		assertMetric(t, rm.ScopeMetrics, attrs, metricdata.Metrics{Name: "rpc.server.response.bytes", Unit: "By", Data: metricdata.Sum[int64]{
+			IsMonotonic: true,
+			DataPoints:  []metricdata.DataPoint[int64]{{Value: responseBytes}},
+		}})
+	}
+
+	rpcCtx := handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Stream"})
+	handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now(), IsServerStream: true})
+	handler.HandleRPC(rpcCtx, &stats.OutPayload{Length: 10, SentTime: time.Now()})
+	handler.HandleRPC(rpcCtx, &stats.OutPayload{Length: 10, SentTime: time.Now()})
+
+	// in-flight messages are reported before the rpc ends
+	collect(2, 20)
+
+	handler.HandleRPC(rpcCtx, &stats.OutPayload{Length: 5, SentTime: time.Now()})
+	handler.HandleRPC(rpcCtx, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+
+	collect(3, 25)
+
+	// totals of ended rpcs are kept
+	rpcCtx = handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Stream"})
+	handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now(), IsServerStream: true})
+	handler.HandleRPC(rpcCtx, &stats.OutPayload{Length: 1, SentTime: time.Now()})
+
+	collect(4, 26)
+}
+
+func TestInterimReportingInterval(t *testing.T) {
+	ctx := context.Background()
+
+	// responses returns the reported response messages or -1 when nothing is reported.
+	responses := func(reader sdkmetric.Reader) int64 {
+		t.Helper()
+
+		var rm metricdata.ResourceMetrics
+
+		assert.NoError(t, reader.Collect(ctx, &rm))
+
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name != "rpc.server.response.messages" {
+					continue
+				}
+
+				for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+					return dp.Value
+				}
+			}
+		}
+
+		return -1
+	}
+
+	send := func(handler *Handler, n int) {
+		rpcCtx := handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Stream"})
+		handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now(), IsServerStream: true})
+
+		for i := 0; i < n; i++ {
+			handler.HandleRPC(rpcCtx, &stats.OutPayload{Length: 10, SentTime: time.Now()})
+		}
+	}
+
+	// the reported totals lag behind until the next tick
+	reader := sdkmetric.NewManualReader()
+
+	handler, err := NewServerHandler(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))), WithInterimReporting(time.Hour))
+	assert.NoError(t, err)
+
+	send(handler, 2)
+	assert.Equal(t, int64(-1), responses(reader))
+	assert.NoError(t, handler.Close())
+
+	// totals are refreshed on each tick and no longer after Close
+	reader = sdkmetric.NewManualReader()
+
+	handler, err = NewServerHandler(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))), WithInterimReporting(time.Millisecond))
+	assert.NoError(t, err)
+
+	send(handler, 2)
+	assert.Eventually(t, func() bool {
+		handler.interim.mu.Lock()
+		defer handler.interim.mu.Unlock()
+
+		for _, c := range handler.interim.snapshot {
+			return c.responses == 2
+		}
+
+		return false
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), responses(reader))
+
+	assert.NoError(t, handler.Close())
+
+	send(handler, 3)
+	time.Sleep(20 * time.Millisecond)
+
+	handler.interim.mu.Lock()
+	assert.Len(t, handler.interim.snapshot, 1)
+	for _, c := range handler.interim.snapshot {
+		assert.Equal(t, int64(2), c.responses)
+	}
+	handler.interim.mu.Unlock()
+}
+
+func TestStuckRPCs(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	var stuck []InFlightRPC
+
+	handler, err := NewServerHandler(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithStuckRPCThreshold(time.Hour),
+		WithStuckRPCsFunc(func(rpcs []InFlightRPC) { stuck = rpcs }),
+	)
+	assert.NoError(t, err)
+
+	assert.Empty(t, handler.InFlight(0))
+
+	disabled, err := NewServerHandler(WithMeterProvider(noop.NewMeterProvider()))
+	assert.NoError(t, err)
+	assert.Nil(t, disabled.InFlight(0))
+
+	begin := func(method string, age time.Duration) context.Context {
+		rpcCtx := handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/" + method})
+		handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: time.Now().Add(-age)})
+
+		return rpcCtx
+	}
+
+	peer := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 54321}
+
+	oldest := begin("Stream", 3*time.Hour)
+	handler.HandleRPC(oldest, &stats.InHeader{RemoteAddr: peer})
+	handler.HandleRPC(oldest, &stats.InPayload{RecvTime: time.Now()})
+	handler.HandleRPC(oldest, &stats.OutPayload{SentTime: time.Now()})
+	handler.HandleRPC(oldest, &stats.OutPayload{SentTime: time.Now()})
+
+	begin("Ok", time.Minute)
+	begin("Stream", 2*time.Hour)
+
+	ended := begin("Stream", 4*time.Hour)
+	handler.HandleRPC(ended, &stats.End{BeginTime: time.Now(), EndTime: time.Now()})
+
+	// oldest first, peer is only known once headers are handled.
+	rpcs := handler.InFlight(0)
+	assert.Len(t, rpcs, 3)
+	assert.Equal(t, "/testserver.TestsService/Stream", rpcs[0].FullMethodName)
+	assert.Equal(t, peer, rpcs[0].Peer)
+	assert.Equal(t, int64(2), rpcs[0].Sent)
+	assert.Equal(t, int64(1), rpcs[0].Received)
+	assert.Equal(t, "/testserver.TestsService/Stream", rpcs[1].FullMethodName)
+	assert.Nil(t, rpcs[1].Peer)
+	assert.Equal(t, "/testserver.TestsService/Ok", rpcs[2].FullMethodName)
+	assert.True(t, rpcs[0].BeginTime.Before(rpcs[1].BeginTime))
+	assert.True(t, rpcs[1].BeginTime.Before(rpcs[2].BeginTime))
+
+	assert.Equal(t, rpcs[:2], handler.InFlight(2))
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	// methods with in-flight rpcs are reported, even when none of them is stuck.
+	values := map[string]int64{}
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.server.stuck_rpcs" {
+			continue
+		}
+
+		for _, dp := range m.Data.(metricdata.Gauge[int64]).DataPoints { //nolint:forcetypeassert
+			method, _ := dp.Attributes.Value("rpc.method")
+			values[method.AsString()] = dp.Value
+		}
+	}
+
+	assert.Equal(t, map[string]int64{"Stream": 2, "Ok": 0}, values)
+
+	// the callback only receives rpcs older than the threshold.
+	assert.Equal(t, rpcs[:2], stuck)
+}
+
+func TestDurationsUseEndTime(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+
+	handler, err := NewServerHandler(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithInstrumentLatency(true),
+		WithSemconvMode(SemconvDuplicate),
+	)
+	assert.NoError(t, err)
+
+	begin := time.Now().Add(-time.Hour)
+
+	rpcCtx := handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/testserver.TestsService/Ok"})
+	handler.HandleRPC(rpcCtx, &stats.Begin{BeginTime: begin})
+	handler.HandleRPC(rpcCtx, &stats.End{BeginTime: begin, EndTime: begin.Add(1500 * time.Millisecond)})
+
+	var rm metricdata.ResourceMetrics
+
+	assert.NoError(t, reader.Collect(ctx, &rm))
+
+	sums := map[string]float64{}
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if h, ok := m.Data.(metricdata.Histogram[float64]); ok {
+			sums[m.Name] = h.DataPoints[0].Sum
+		}
+	}
+
+	// both durations are measured until the end of the rpc, not until it is handled.
+	assert.Equal(t, map[string]float64{"rpc.server.duration": 1500, "rpc.server.call.duration": 1.5}, sums)
+}
+
+func assertMetric(t *testing.T, inMetrics []metricdata.ScopeMetrics, attrs []attribute.KeyValue, has metricdata.Metrics) {
+	t.Helper()
+
+	for _, sm := range inMetrics {
+		assert.Equal(t, DefaultInstrumentationName, sm.Scope.Name)
+
+		for _, m := range sm.Metrics {
 			if m.Name == has.Name {
 				assert.Equal(t, has.Unit, m.Unit)
 
@@ -355,10 +2382,35 @@ func assertMetric(t *testing.T, inMetrics []metricdata.ScopeMetrics, attrs []att
 					for i := range inData.DataPoints {
 						assert.Equal(t, inData.DataPoints[i].Count, d.DataPoints[i].Count)
 
-						if m.Unit != "ms" { // ignore sum check for time duration which is flaky
+						if m.Unit != "ms" && m.Unit != "s" { // ignore sum check for time duration which is flaky
+							assert.Equal(t, inData.DataPoints[i].Sum, d.DataPoints[i].Sum)
+						}
+
+						assert.ElementsMatch(t, attrs, d.DataPoints[i].Attributes.ToSlice())
+					}
+				case metricdata.Histogram[float64]:
+					inData, ok := has.Data.(metricdata.Histogram[float64])
+					assert.True(t, ok, "invalid data type")
+
+					assert.Equal(t, len(inData.DataPoints), len(d.DataPoints))
+
+					for i := range inData.DataPoints {
+						assert.Equal(t, inData.DataPoints[i].Count, d.DataPoints[i].Count)
+
+						if m.Unit != "ms" && m.Unit != "s" { // ignore sum check for time duration which is flaky
 							assert.Equal(t, inData.DataPoints[i].Sum, d.DataPoints[i].Sum)
 						}
 
+						assert.ElementsMatch(t, attrs, d.DataPoints[i].Attributes.ToSlice())
+					}
+				case metricdata.Gauge[int64]:
+					inData, ok := has.Data.(metricdata.Gauge[int64])
+					assert.True(t, ok, "invalid data type")
+
+					assert.Equal(t, len(inData.DataPoints), len(d.DataPoints))
+
+					for i := range inData.DataPoints {
+						assert.Equal(t, inData.DataPoints[i].Value, d.DataPoints[i].Value)
 						assert.ElementsMatch(t, attrs, d.DataPoints[i].Attributes.ToSlice())
 					}
 				case metricdata.Sum[int64]:
@@ -0,0 +1,147 @@
+package grpcmetrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// defaultMaxCallerIdentities is the number of distinct caller identities recorded when WithCallerIdentity is given no limit.
+const defaultMaxCallerIdentities = 100
+
+// callerIdentityKey is rpc.caller.identity attribute recorded with WithCallerIdentity.
+var callerIdentityKey = attribute.Key("rpc.caller.identity")
+
+// tls.protocol.version and tls.cipher attributes which are not available in semconv/v1.21.0 yet.
+var (
+	tlsProtocolVersionKey = attribute.Key("tls.protocol.version")
+	tlsCipherKey          = attribute.Key("tls.cipher")
+)
+
+// CallerIdentityFunc returns the identity of an authenticated caller from the state of its TLS connection,
+// or empty string if it has none.
+type CallerIdentityFunc func(state tls.ConnectionState) string
+
+// SPIFFEIdentity is a CallerIdentityFunc returning the SPIFFE ID of the caller, the spiffe:// URI SAN of its certificate.
+// The certificate is taken from the verified chains, or from the peer certificates when they are verified
+// by tls.Config.VerifyPeerCertificate instead, like go-spiffe does.
+func SPIFFEIdentity(state tls.ConnectionState) string {
+	var leaf *x509.Certificate
+
+	switch {
+	case len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0:
+		leaf = state.VerifiedChains[0][0]
+	case len(state.PeerCertificates) > 0:
+		leaf = state.PeerCertificates[0]
+	default:
+		return ""
+	}
+
+	for _, u := range leaf.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String()
+		}
+	}
+
+	return ""
+}
+
+// tlsVersion returns the tls.protocol.version value of version, or empty string if it is unknown.
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+
+	return ""
+}
+
+// callerAttributes computes the rpc.caller.identity and TLS attributes of server rpcs from the peer of the rpc.
+type callerAttributes struct {
+	identityFunc  CallerIdentityFunc
+	maxIdentities int
+	tls           bool
+
+	mu sync.Mutex
+	// distinct identities recorded so far
+	identities map[string]struct{}
+}
+
+func newCallerAttributes(c config, isClient bool) *callerAttributes {
+	if isClient || (c.callerIdentityFunc == nil && !c.tlsAttributes) {
+		return nil
+	}
+
+	a := &callerAttributes{
+		identityFunc:  c.callerIdentityFunc,
+		maxIdentities: c.maxCallerIdentities,
+		tls:           c.tlsAttributes,
+		identities:    make(map[string]struct{}),
+	}
+
+	if a.maxIdentities <= 0 {
+		a.maxIdentities = defaultMaxCallerIdentities
+	}
+
+	return a
+}
+
+// identity returns id if it's one of the first maxIdentities distinct identities, otherwise "_OTHER".
+func (a *callerAttributes) identity(id string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.identities[id]; ok {
+		return id
+	}
+
+	if len(a.identities) >= a.maxIdentities {
+		return otherValue
+	}
+
+	a.identities[id] = struct{}{}
+
+	return id
+}
+
+// get returns the attributes of the peer of ctx, nil unless it's connected over TLS.
+func (a *callerAttributes) get(ctx context.Context) []attribute.KeyValue {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+
+	attr := make([]attribute.KeyValue, 0, 3) //nolint:gomnd
+
+	if a.identityFunc != nil {
+		if id := a.identityFunc(info.State); id != "" {
+			attr = append(attr, callerIdentityKey.String(a.identity(id)))
+		}
+	}
+
+	if a.tls {
+		if version := tlsVersion(info.State.Version); version != "" {
+			attr = append(attr, tlsProtocolVersionKey.String(version))
+		}
+
+		attr = append(attr, tlsCipherKey.String(tls.CipherSuiteName(info.State.CipherSuite)))
+	}
+
+	return attr
+}
@@ -0,0 +1,136 @@
+package grpcmetrics
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// InFlightRPC describes an rpc which has begun but not ended yet.
+type InFlightRPC struct {
+	FullMethodName string
+	BeginTime      time.Time
+	// Peer is the address of the other side of the rpc, nil until headers are handled.
+	Peer     net.Addr
+	Sent     int64
+	Received int64
+}
+
+// StuckRPCsFunc is called on each collection with the rpcs in-flight for longer than the stuck threshold, oldest first.
+type StuckRPCsFunc func(rpcs []InFlightRPC)
+
+// inFlight is the registry of in-flight rpcs used to detect rpcs which never reach End.
+type inFlight struct {
+	threshold time.Duration
+	stuckFunc StuckRPCsFunc
+
+	mu   sync.Mutex
+	rpcs map[*rpcInfo]time.Time
+}
+
+func newInFlight(threshold time.Duration, stuckFunc StuckRPCsFunc) *inFlight {
+	return &inFlight{threshold: threshold, stuckFunc: stuckFunc, rpcs: make(map[*rpcInfo]time.Time)}
+}
+
+func (f *inFlight) begin(ri *rpcInfo, beginTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rpcs[ri] = beginTime
+}
+
+func (f *inFlight) end(ri *rpcInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.rpcs, ri)
+}
+
+// list returns the in-flight rpcs which began before the given time sorted oldest first, limited to n unless n <= 0.
+func (f *inFlight) list(before time.Time, n int) []InFlightRPC {
+	f.mu.Lock()
+
+	rpcs := make([]InFlightRPC, 0, len(f.rpcs))
+
+	for ri, beginTime := range f.rpcs {
+		if !beginTime.Before(before) {
+			continue
+		}
+
+		ri.mu.Lock()
+		peer := ri.peerAddr
+		ri.mu.Unlock()
+
+		rpcs = append(rpcs, InFlightRPC{
+			FullMethodName: ri.fullMethodName,
+			BeginTime:      beginTime,
+			Peer:           peer,
+			Sent:           atomic.LoadInt64(&ri.sentMsgs),
+			Received:       atomic.LoadInt64(&ri.recvMsgs),
+		})
+	}
+
+	f.mu.Unlock()
+
+	sort.Slice(rpcs, func(i, j int) bool { return rpcs[i].BeginTime.Before(rpcs[j].BeginTime) })
+
+	if n > 0 && len(rpcs) > n {
+		rpcs = rpcs[:n]
+	}
+
+	return rpcs
+}
+
+// observe reports the number of stuck rpcs of every method with in-flight rpcs, and passes them to the StuckRPCsFunc.
+func (f *inFlight) observe(_ context.Context, o metric.Int64Observer) error {
+	stuckBefore := time.Now().Add(-f.threshold)
+
+	f.mu.Lock()
+
+	methods := make(map[attribute.Distinct]int64)
+	attrs := make(map[attribute.Distinct]attribute.Set)
+
+	for ri, beginTime := range f.rpcs {
+		k := ri.methodAttrs.Equivalent()
+
+		// methods without stuck rpcs are reported as 0 while they have in-flight rpcs.
+		if _, ok := methods[k]; !ok {
+			methods[k] = 0
+			attrs[k] = ri.methodAttrs
+		}
+
+		if beginTime.Before(stuckBefore) {
+			methods[k]++
+		}
+	}
+
+	f.mu.Unlock()
+
+	for k, stuck := range methods {
+		o.Observe(stuck, metric.WithAttributeSet(attrs[k]))
+	}
+
+	if f.stuckFunc != nil {
+		if stuck := f.list(stuckBefore, 0); len(stuck) > 0 {
+			f.stuckFunc(stuck)
+		}
+	}
+
+	return nil
+}
+
+// InFlight returns up to n of the oldest in-flight rpcs, or all of them if n <= 0.
+// It returns nil unless stuck rpc detection is enabled with WithStuckRPCThreshold.
+func (h *Handler) InFlight(n int) []InFlightRPC {
+	if h.inFlight == nil {
+		return nil
+	}
+
+	return h.inFlight.list(time.Now(), n)
+}
@@ -0,0 +1,192 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type interimCounts struct {
+	attrs         attribute.Set
+	requests      int64
+	responses     int64
+	requestBytes  int64
+	responseBytes int64
+}
+
+// interimReporter reports message and byte totals per method including rpcs which are still in-flight,
+// so the throughput of long-lived streams is visible before they end.
+type interimReporter struct {
+	isClient bool
+	interval time.Duration
+
+	mu sync.Mutex
+	// in-flight rpcs
+	active map[*rpcInfo]struct{}
+	// totals of ended rpcs
+	ended map[attribute.Distinct]*interimCounts
+	// totals taken on the last tick, only used with an interval
+	snapshot map[attribute.Distinct]*interimCounts
+
+	requests      metric.Int64ObservableCounter
+	responses     metric.Int64ObservableCounter
+	requestBytes  metric.Int64ObservableCounter
+	responseBytes metric.Int64ObservableCounter
+
+	registration metric.Registration
+	stop         chan struct{}
+	stopOnce     sync.Once
+	// closed once the interval goroutine returns
+	done chan struct{}
+}
+
+func newInterimReporter(meter metric.Meter, prefix string, isClient bool, interval time.Duration) (*interimReporter, error) {
+	var err error
+
+	r := &interimReporter{
+		isClient: isClient,
+		interval: interval,
+		active:   make(map[*rpcInfo]struct{}),
+		ended:    make(map[attribute.Distinct]*interimCounts),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	r.requests, err = meter.Int64ObservableCounter(prefix+".request.messages", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	r.responses, err = meter.Int64ObservableCounter(prefix+".response.messages", metric.WithUnit("1"))
+	if err != nil {
+		return nil, err
+	}
+
+	r.requestBytes, err = meter.Int64ObservableCounter(prefix+".request.bytes", metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	r.responseBytes, err = meter.Int64ObservableCounter(prefix+".response.bytes", metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	r.registration, err = meter.RegisterCallback(r.observe, r.requests, r.responses, r.requestBytes, r.responseBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		r.snapshot = make(map[attribute.Distinct]*interimCounts)
+
+		go r.run()
+	} else {
+		close(r.done)
+	}
+
+	return r, nil
+}
+
+func (r *interimReporter) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			r.snapshot = r.totals()
+			r.mu.Unlock()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// add the current counts of ri to the totals of its method.
+func (r *interimReporter) add(totals map[attribute.Distinct]*interimCounts, ri *rpcInfo) {
+	c, ok := totals[ri.methodAttrs.Equivalent()]
+	if !ok {
+		c = &interimCounts{attrs: ri.methodAttrs}
+		totals[ri.methodAttrs.Equivalent()] = c
+	}
+
+	requests, responses := atomic.LoadInt64(&ri.recvMsgs), atomic.LoadInt64(&ri.sentMsgs)
+	requestBytes, responseBytes := atomic.LoadInt64(&ri.recvBytes), atomic.LoadInt64(&ri.sentBytes)
+
+	if r.isClient {
+		requests, responses = responses, requests
+		requestBytes, responseBytes = responseBytes, requestBytes
+	}
+
+	c.requests += requests
+	c.responses += responses
+	c.requestBytes += requestBytes
+	c.responseBytes += responseBytes
+}
+
+// totals returns the totals of ended rpcs plus the current counts of in-flight ones, r.mu must be held.
+func (r *interimReporter) totals() map[attribute.Distinct]*interimCounts {
+	totals := make(map[attribute.Distinct]*interimCounts, len(r.ended))
+
+	for k, e := range r.ended {
+		c := *e
+		totals[k] = &c
+	}
+
+	for ri := range r.active {
+		r.add(totals, ri)
+	}
+
+	return totals
+}
+
+func (r *interimReporter) begin(ri *rpcInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.active[ri] = struct{}{}
+}
+
+// end moves the final counts of ri to the totals of ended rpcs.
+func (r *interimReporter) end(ri *rpcInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.active, ri)
+	r.add(r.ended, ri)
+}
+
+// observe reports the totals on each collection, or the totals taken on the last tick when an interval is configured.
+func (r *interimReporter) observe(_ context.Context, o metric.Observer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totals := r.snapshot
+	if r.interval <= 0 {
+		totals = r.totals()
+	}
+
+	for _, c := range totals {
+		o.ObserveInt64(r.requests, c.requests, metric.WithAttributeSet(c.attrs))
+		o.ObserveInt64(r.responses, c.responses, metric.WithAttributeSet(c.attrs))
+		o.ObserveInt64(r.requestBytes, c.requestBytes, metric.WithAttributeSet(c.attrs))
+		o.ObserveInt64(r.responseBytes, c.responseBytes, metric.WithAttributeSet(c.attrs))
+	}
+
+	return nil
+}
+
+func (r *interimReporter) close() error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+
+	return r.registration.Unregister()
+}
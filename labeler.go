@@ -0,0 +1,47 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Labeler is used to add attributes to the metrics recorded when the current rpc ends.
+// It is safe for concurrent use, e.g. from the goroutines of a streaming handler.
+type Labeler struct {
+	mu    sync.Mutex
+	attrs []attribute.KeyValue
+}
+
+// Add attributes to the Labeler.
+func (l *Labeler) Add(attrs ...attribute.KeyValue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.attrs = append(l.attrs, attrs...)
+}
+
+// Get returns a copy of the attributes added to the Labeler.
+func (l *Labeler) Get() []attribute.KeyValue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ret := make([]attribute.KeyValue, len(l.attrs))
+	copy(ret, l.attrs)
+
+	return ret
+}
+
+// LabelerFromContext retrieves the Labeler of the rpc instrumented by a Handler from the context.
+// On the server side this is the context passed to the service method.
+// If there is no rpc in the context a new Labeler is returned and the second return value is false;
+// attributes added to it are not recorded anywhere.
+func LabelerFromContext(ctx context.Context) (*Labeler, bool) {
+	ri := getRPCInfo(ctx)
+	if ri == nil {
+		return &Labeler{}, false
+	}
+
+	return &ri.labeler, true
+}
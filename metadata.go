@@ -0,0 +1,73 @@
+package grpcmetrics
+
+import (
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataAttributes records allowlisted request metadata as rpc.grpc.request.metadata.<key> attributes.
+type metadataAttributes struct {
+	keys      []string
+	attrKeys  []attribute.Key
+	maxLength int
+	fallback  string
+}
+
+func newMetadataAttributes(c config) *metadataAttributes {
+	if len(c.metadataKeys) == 0 {
+		return nil
+	}
+
+	m := &metadataAttributes{
+		keys:      make([]string, 0, len(c.metadataKeys)),
+		attrKeys:  make([]attribute.Key, 0, len(c.metadataKeys)),
+		maxLength: c.metadataMaxLength,
+		fallback:  c.metadataFallback,
+	}
+
+	for _, key := range c.metadataKeys {
+		key = strings.ToLower(key)
+		m.keys = append(m.keys, key)
+		m.attrKeys = append(m.attrKeys, attribute.Key("rpc.grpc.request.metadata."+key))
+	}
+
+	return m
+}
+
+func (m *metadataAttributes) get(md metadata.MD) []attribute.KeyValue {
+	attr := make([]attribute.KeyValue, 0, len(m.keys))
+
+	for i, key := range m.keys {
+		values := md[key]
+
+		if len(values) == 0 {
+			if m.fallback == "" {
+				continue
+			}
+
+			values = []string{m.fallback}
+		} else if m.maxLength > 0 {
+			values = truncateValues(values, m.maxLength)
+		}
+
+		attr = append(attr, m.attrKeys[i].StringSlice(values))
+	}
+
+	return attr
+}
+
+func truncateValues(values []string, maxLength int) []string {
+	ret := make([]string, len(values))
+
+	for i, v := range values {
+		if len(v) > maxLength {
+			v = strings.ToValidUTF8(v[:maxLength], "")
+		}
+
+		ret[i] = v
+	}
+
+	return ret
+}
@@ -0,0 +1,104 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	metadataKindKey      = attribute.Key("rpc.grpc.metadata.kind")
+	metadataDirectionKey = attribute.Key("rpc.grpc.metadata.direction")
+
+	// headerFieldOverhead is the per field overhead used for the HTTP/2 header list size, see RFC 7540 section 6.5.2.
+	headerFieldOverhead = 32
+)
+
+// metadataSizes are the instruments enabled by WithInstrumentMetadataSizes and WithMetadataSizeThreshold.
+type metadataSizes struct {
+	keys     metric.Int64Histogram
+	size     metric.Int64Histogram
+	wireSize metric.Int64Histogram
+
+	threshold int
+	oversized metric.Int64Counter
+}
+
+// newMetadataSizes returns nil if neither metadata sizes nor the threshold are configured.
+func newMetadataSizes(meter metric.Meter, prefix string, c config) (*metadataSizes, error) {
+	if !c.instrumentMetadataSizes && c.metadataSizeThreshold <= 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	var (
+		m   metadataSizes
+		err error
+	)
+
+	if c.instrumentMetadataSizes {
+		m.keys, err = meter.Int64Histogram(prefix+".metadata.keys", metric.WithUnit("1"))
+		if err != nil {
+			return nil, err
+		}
+
+		m.size, err = meter.Int64Histogram(prefix+".metadata.size", metric.WithUnit("By"))
+		if err != nil {
+			return nil, err
+		}
+
+		m.wireSize, err = meter.Int64Histogram(prefix+".metadata.wire_size", metric.WithUnit("By"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.metadataSizeThreshold > 0 {
+		m.threshold = c.metadataSizeThreshold
+
+		m.oversized, err = meter.Int64Counter(prefix+".metadata.oversized", metric.WithUnit("1"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &m, nil
+}
+
+// metadataSize returns the size of md as accounted against the HTTP/2 header list limit.
+func metadataSize(md metadata.MD) int {
+	size := 0
+
+	for k, vs := range md {
+		for _, v := range vs {
+			size += len(k) + len(v) + headerFieldOverhead
+		}
+	}
+
+	return size
+}
+
+// record the size of a header or trailer, wireLength is only recorded when the event provides it.
+func (m *metadataSizes) record(ctx context.Context, ri *rpcInfo, kind, direction string, md metadata.MD, wireLength int) {
+	size := metadataSize(md)
+	attrs := metric.WithAttributeSet(attribute.NewSet(append(ri.methodAttrs.ToSlice(),
+		metadataKindKey.String(kind),
+		metadataDirectionKey.String(direction),
+	)...))
+
+	if m.size != nil {
+		m.keys.Record(ctx, int64(len(md)), attrs)
+		m.size.Record(ctx, int64(size), attrs)
+
+		if wireLength > 0 {
+			m.wireSize.Record(ctx, int64(wireLength), attrs)
+		}
+	}
+
+	// an rpc is counted once, by the first header or trailer crossing the threshold.
+	if m.oversized != nil && size > m.threshold && atomic.CompareAndSwapInt32(&ri.metadataOversized, 0, 1) {
+		m.oversized.Add(ctx, 1, attrs)
+	}
+}
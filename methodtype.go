@@ -0,0 +1,29 @@
+package grpcmetrics
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// methodTypeKey is the rpc.grpc.method_type attribute recorded with WithMethodTypeAttribute.
+var methodTypeKey = attribute.Key("rpc.grpc.method_type")
+
+const (
+	methodTypeUnary           = "unary"
+	methodTypeClientStreaming = "client_streaming"
+	methodTypeServerStreaming = "server_streaming"
+	methodTypeBidiStreaming   = "bidi_streaming"
+)
+
+// getMethodType returns the rpc.grpc.method_type value of a method streaming from the client and/or server.
+func getMethodType(isClientStream, isServerStream bool) string {
+	switch {
+	case isClientStream && isServerStream:
+		return methodTypeBidiStreaming
+	case isClientStream:
+		return methodTypeClientStreaming
+	case isServerStream:
+		return methodTypeServerStreaming
+	default:
+		return methodTypeUnary
+	}
+}
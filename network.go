@@ -0,0 +1,156 @@
+package grpcmetrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// network.local.address and network.peer.address attributes which are not available in semconv/v1.21.0 yet.
+var (
+	networkLocalAddressKey = attribute.Key("network.local.address")
+	networkPeerAddressKey  = attribute.Key("network.peer.address")
+)
+
+// networkAttributes computes the network.* and server.port attributes of the connection an rpc is sent on.
+type networkAttributes struct {
+	isClient bool
+	// network.peer.address is truncated to these prefix lengths, it's not recorded for a family with a 0 prefix length.
+	peerIPv4PrefixLen int
+	peerIPv6PrefixLen int
+}
+
+func newNetworkAttributes(c config, isClient bool) *networkAttributes {
+	if !c.networkAttributes {
+		return nil
+	}
+
+	return &networkAttributes{
+		isClient:          isClient,
+		peerIPv4PrefixLen: clampPrefixLen(c.networkPeerIPv4PrefixLen, 8*net.IPv4len),
+		peerIPv6PrefixLen: clampPrefixLen(c.networkPeerIPv6PrefixLen, 8*net.IPv6len),
+	}
+}
+
+// clampPrefixLen returns prefixLen limited to the bits of an address family, net.CIDRMask returns nil out of that range.
+func clampPrefixLen(prefixLen, bits int) int {
+	if prefixLen < 0 {
+		return 0
+	}
+
+	if prefixLen > bits {
+		return bits
+	}
+
+	return prefixLen
+}
+
+// splitAddr returns the host, port and ip of addr. port is 0 and ip is nil when addr has none.
+func splitAddr(addr net.Addr) (string, int, net.IP) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		// unix sockets are named by their path
+		return addr.String(), 0, nil
+	}
+
+	p, _ := strconv.Atoi(port)
+
+	return host, p, net.ParseIP(host)
+}
+
+// networkTransport returns the network.transport attribute of a net.Addr network, or false if it's unknown.
+func networkTransport(network string) (attribute.KeyValue, bool) {
+	switch {
+	case strings.HasPrefix(network, "tcp"):
+		return semconv.NetworkTransportTCP, true
+	case strings.HasPrefix(network, "udp"):
+		return semconv.NetworkTransportUDP, true
+	case strings.HasPrefix(network, "unix"):
+		return semconv.NetworkTransportUnix, true
+	case network == "pipe":
+		return semconv.NetworkTransportPipe, true
+	}
+
+	return attribute.KeyValue{}, false
+}
+
+// get returns the attributes of a connection between local and remote, either of them can be nil when unknown.
+func (n *networkAttributes) get(local, remote net.Addr) []attribute.KeyValue {
+	attr := make([]attribute.KeyValue, 0, 5) //nolint:gomnd
+
+	var (
+		localHost             string
+		localPort, remotePort int
+		localIP, remoteIP     net.IP
+	)
+
+	if local != nil {
+		localHost, localPort, localIP = splitAddr(local)
+	}
+
+	if remote != nil {
+		_, remotePort, remoteIP = splitAddr(remote)
+	}
+
+	addr := local
+	if addr == nil {
+		addr = remote
+	}
+
+	if addr != nil {
+		if transport, ok := networkTransport(addr.Network()); ok {
+			attr = append(attr, transport)
+		}
+	}
+
+	ip := localIP
+	if ip == nil {
+		ip = remoteIP
+	}
+
+	switch {
+	case ip.To4() != nil:
+		attr = append(attr, semconv.NetworkTypeIpv4)
+	case ip.To16() != nil:
+		attr = append(attr, semconv.NetworkTypeIpv6)
+	}
+
+	serverPort := localPort
+	if n.isClient {
+		serverPort = remotePort
+	}
+
+	if serverPort != 0 {
+		attr = append(attr, semconv.ServerPort(serverPort))
+	}
+
+	if localHost != "" {
+		attr = append(attr, networkLocalAddressKey.String(localHost))
+	}
+
+	if peer := n.peerAddress(remoteIP); peer != "" {
+		attr = append(attr, networkPeerAddressKey.String(peer))
+	}
+
+	return attr
+}
+
+// peerAddress returns ip truncated to the configured prefix length of its family, or empty string if it's not recorded.
+func (n *networkAttributes) peerAddress(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		if n.peerIPv4PrefixLen <= 0 {
+			return ""
+		}
+
+		return ip4.Mask(net.CIDRMask(n.peerIPv4PrefixLen, 8*net.IPv4len)).String()
+	}
+
+	if ip.To16() != nil && n.peerIPv6PrefixLen > 0 {
+		return ip.Mask(net.CIDRMask(n.peerIPv6PrefixLen, 8*net.IPv6len)).String()
+	}
+
+	return ""
+}
@@ -0,0 +1,66 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+)
+
+// errorOriginKey is the error.origin attribute recorded with WithErrorOrigin.
+var errorOriginKey = attribute.Key("error.origin")
+
+const (
+	// originLocal is a deadline or cancellation of the client's own context.
+	originLocal = "local"
+	// originRemote is a deadline or cancellation of the peer, the server on clients and the caller on servers.
+	originRemote = "remote"
+	// originPropagated is a deadline or cancellation returned by the server handler while its caller is still waiting,
+	// usually from a downstream call.
+	originPropagated = "propagated"
+)
+
+// getErrorOrigin returns who caused the DeadlineExceeded or Canceled status of a finished rpc, or empty string for other codes.
+// Clients tell their own context errors apart from statuses sent by the server by the received trailers. Servers consider
+// the caller responsible once the deadline of the rpc is over, or for a cancellation when no trailers were sent since
+// the status is only dropped when the caller resets the stream.
+func getErrorOrigin(ctx context.Context, ri *rpcInfo, rs *stats.End, code codes.Code, isClient bool) string {
+	if code != codes.DeadlineExceeded && code != codes.Canceled {
+		return ""
+	}
+
+	trailers := atomic.LoadInt32(&ri.trailers) == 1
+
+	if isClient {
+		if trailers {
+			return originRemote
+		}
+
+		return originLocal
+	}
+
+	if code == codes.DeadlineExceeded {
+		if deadline, ok := ctx.Deadline(); ok && !rs.EndTime.Before(deadline) {
+			return originRemote
+		}
+
+		return originPropagated
+	}
+
+	// gRPC cancels the context of every server stream before stats.End, only the trailers tell who canceled it.
+	if !trailers {
+		return originRemote
+	}
+
+	return originPropagated
+}
+
+func errorOriginAttributes(origin string) []attribute.KeyValue {
+	if origin == "" {
+		return nil
+	}
+
+	return []attribute.KeyValue{errorOriginKey.String(origin)}
+}
@@ -0,0 +1,105 @@
+package grpcmetrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/stats"
+)
+
+// latencyPhases are the instruments enabled by WithInstrumentLatencyPhases.
+type latencyPhases struct {
+	mode SemconvMode
+
+	// client only, from begin to the response headers
+	timeToFirstHeader metric.Float64Histogram
+	// from begin to the first response message received by the client or sent by the server
+	timeToFirstResponse metric.Float64Histogram
+	// from the last message to the end of the rpc, which is when trailers are handled
+	trailerLatency metric.Float64Histogram
+}
+
+func newLatencyPhases(meter metric.Meter, prefix string, isClient bool, mode SemconvMode) (*latencyPhases, error) {
+	var err error
+
+	p := latencyPhases{mode: mode}
+	unit := durationUnit(mode)
+
+	if isClient {
+		p.timeToFirstHeader, err = meter.Float64Histogram(prefix+".time_to_first_header", metric.WithUnit(unit))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.timeToFirstResponse, err = meter.Float64Histogram(prefix+".time_to_first_response", metric.WithUnit(unit))
+	if err != nil {
+		return nil, err
+	}
+
+	p.trailerLatency, err = meter.Float64Histogram(prefix+".trailer_latency", metric.WithUnit(unit))
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// durationUnit returns the unit of durations, legacy durations are in milliseconds while semantic conventions use seconds.
+func durationUnit(mode SemconvMode) string {
+	if mode == SemconvLegacy {
+		return "ms"
+	}
+
+	return "s"
+}
+
+// durationValue returns d in the unit of durationUnit.
+func durationValue(mode SemconvMode, d time.Duration) float64 {
+	if mode == SemconvLegacy {
+		return float64(d) / float64(time.Millisecond)
+	}
+
+	return d.Seconds()
+}
+
+func (p *latencyPhases) value(d time.Duration) float64 {
+	return durationValue(p.mode, d)
+}
+
+// header marks the time of the first header.
+func (p *latencyPhases) header(ri *rpcInfo) {
+	atomic.CompareAndSwapInt64(&ri.firstHeaderTime, 0, time.Now().UnixNano())
+}
+
+// message marks the time of a message, t is only used as the first response if it's a response.
+func (p *latencyPhases) message(ri *rpcInfo, t time.Time, isResponse bool) {
+	if isResponse {
+		atomic.CompareAndSwapInt64(&ri.firstResponseTime, 0, t.UnixNano())
+	}
+
+	atomic.StoreInt64(&ri.lastMessageTime, t.UnixNano())
+}
+
+// record the phases which happened during the rpc, phases which did not happen are not recorded.
+func (p *latencyPhases) record(ctx context.Context, ri *rpcInfo, end *stats.End, attrs attribute.Set) {
+	begin := end.BeginTime.UnixNano()
+
+	if t := atomic.LoadInt64(&ri.firstHeaderTime); t != 0 && p.timeToFirstHeader != nil {
+		p.timeToFirstHeader.Record(ctx, p.value(time.Duration(t-begin)), metric.WithAttributeSet(attrs))
+	}
+
+	if t := atomic.LoadInt64(&ri.firstResponseTime); t != 0 {
+		p.timeToFirstResponse.Record(ctx, p.value(time.Duration(t-begin)), metric.WithAttributeSet(attrs))
+	}
+
+	last := atomic.LoadInt64(&ri.lastMessageTime)
+	if last == 0 {
+		last = begin
+	}
+
+	p.trailerLatency.Record(ctx, p.value(time.Duration(end.EndTime.UnixNano()-last)), metric.WithAttributeSet(attrs))
+}
@@ -0,0 +1,41 @@
+package grpcmetrics
+
+import (
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/metadata"
+)
+
+// previousAttemptsHeader is sent by gRPC clients on retries of an rpc with the number of previous attempts.
+const previousAttemptsHeader = "grpc-previous-rpc-attempts"
+
+// maxRetryAttempt is the highest rpc.grpc.retry_attempt value, higher attempts are recorded as "3+".
+const maxRetryAttempt = 3
+
+// retryAttemptKey is the rpc.grpc.retry_attempt attribute recorded with WithRetryAttempts.
+var retryAttemptKey = attribute.Key("rpc.grpc.retry_attempt")
+
+// getRetryAttempt returns the number of previous attempts of the rpc, 0 when it's not a retry.
+func getRetryAttempt(md metadata.MD) int {
+	values := md.Get(previousAttemptsHeader)
+	if len(values) == 0 {
+		return 0
+	}
+
+	attempt, err := strconv.Atoi(values[0])
+	if err != nil || attempt < 0 {
+		return 0
+	}
+
+	return attempt
+}
+
+// retryAttemptValue returns the bounded rpc.grpc.retry_attempt value of attempt.
+func retryAttemptValue(attempt int) string {
+	if attempt >= maxRetryAttempt {
+		return strconv.Itoa(maxRetryAttempt) + "+"
+	}
+
+	return strconv.Itoa(attempt)
+}
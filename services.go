@@ -0,0 +1,66 @@
+package grpcmetrics
+
+import (
+	"errors"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	// otherValue replaces rpc.service and rpc.method of unknown or malformed method names.
+	otherValue = "_OTHER"
+	// otherMethod is the full method name recorded for unknown methods.
+	otherMethod = "/" + otherValue + "/" + otherValue
+)
+
+var errRegisterServicesOnClient = errors.New("grpcmetrics: RegisterServices is only supported on server handlers")
+
+// ServiceInfoProvider is implemented by grpc.Server to expose the registered services.
+type ServiceInfoProvider interface {
+	GetServiceInfo() map[string]grpc.ServiceInfo
+}
+
+// RegisterServices makes the Handler record rpc.service and rpc.method only for methods registered on p,
+// every other method is recorded as "_OTHER" to protect against unbounded cardinality from wire supplied names.
+// It should be called after all services are registered on the server and before it starts serving.
+// Clients can not know which methods a server implements, it returns an error on client handlers.
+func (h *Handler) RegisterServices(p ServiceInfoProvider) error {
+	if h.isClient {
+		return errRegisterServicesOnClient
+	}
+
+	methods := make(map[string]grpc.MethodInfo)
+
+	for service, info := range p.GetServiceInfo() {
+		for _, m := range info.Methods {
+			methods["/"+service+"/"+m.Name] = m
+		}
+	}
+
+	h.methods.Store(&methods)
+
+	return nil
+}
+
+// knownMethod reports whether fullMethodName is registered, always true when RegisterServices is not used.
+func (h *Handler) knownMethod(fullMethodName string) bool {
+	if h.methods.Load() == nil {
+		return true
+	}
+
+	_, ok := h.registeredMethod(fullMethodName)
+
+	return ok
+}
+
+// registeredMethod returns the info of fullMethodName if it's registered with RegisterServices.
+func (h *Handler) registeredMethod(fullMethodName string) (grpc.MethodInfo, bool) {
+	methods := h.methods.Load()
+	if methods == nil {
+		return grpc.MethodInfo{}, false
+	}
+
+	m, ok := (*methods)[fullMethodName]
+
+	return m, ok
+}
@@ -0,0 +1,154 @@
+package grpcmetrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// unknownZone is recorded for addresses which are not resolved to a zone.
+const unknownZone = "unknown"
+
+// local.zone and peer.zone attributes recorded with WithZoneResolver.
+var (
+	localZoneKey = attribute.Key("local.zone")
+	peerZoneKey  = attribute.Key("peer.zone")
+)
+
+// ZoneResolver returns the zone or region of an ip address, or empty string if it's unknown.
+type ZoneResolver interface {
+	Zone(ip net.IP) string
+}
+
+type cidrZone struct {
+	ipNet *net.IPNet
+	ones  int
+	zone  string
+}
+
+// CIDRZoneResolver is a ZoneResolver mapping CIDR ranges to zones, the most specific range containing an address wins.
+type CIDRZoneResolver struct {
+	// sorted by prefix length, longest first
+	ranges []cidrZone
+}
+
+// NewCIDRZoneResolver returns a CIDRZoneResolver reading lines of a CIDR range and its zone separated by whitespace
+// from r, e.g. "10.1.0.0/16 us-east-1a". Empty lines and lines starting with # are ignored.
+func NewCIDRZoneResolver(r io.Reader) (*CIDRZoneResolver, error) {
+	z := &CIDRZoneResolver{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 { //nolint:gomnd
+			return nil, fmt.Errorf("grpcmetrics: line %d: expected a CIDR range and a zone, got %q", line, text)
+		}
+
+		_, ipNet, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("grpcmetrics: line %d: %w", line, err)
+		}
+
+		ones, _ := ipNet.Mask.Size()
+		z.ranges = append(z.ranges, cidrZone{ipNet: ipNet, ones: ones, zone: fields[1]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(z.ranges, func(i, j int) bool { return z.ranges[i].ones > z.ranges[j].ones })
+
+	return z, nil
+}
+
+// LoadCIDRZoneResolver returns a CIDRZoneResolver read from the file at path, see NewCIDRZoneResolver for the format.
+func LoadCIDRZoneResolver(path string) (*CIDRZoneResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewCIDRZoneResolver(f)
+}
+
+// Zone implements ZoneResolver.
+func (z *CIDRZoneResolver) Zone(ip net.IP) string {
+	for _, r := range z.ranges {
+		if r.ipNet.Contains(ip) {
+			return r.zone
+		}
+	}
+
+	return ""
+}
+
+// zoneTraffic are the instruments enabled by WithZoneResolver.
+type zoneTraffic struct {
+	resolver ZoneResolver
+	sent     metric.Int64Counter
+	received metric.Int64Counter
+}
+
+func newZoneTraffic(meter metric.Meter, prefix string, resolver ZoneResolver) (*zoneTraffic, error) {
+	var err error
+
+	z := &zoneTraffic{resolver: resolver}
+
+	z.sent, err = meter.Int64Counter(prefix+".zone.sent_bytes", metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	z.received, err = meter.Int64Counter(prefix+".zone.received_bytes", metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+func (z *zoneTraffic) zone(addr net.Addr) string {
+	if addr == nil {
+		return unknownZone
+	}
+
+	_, _, ip := splitAddr(addr)
+	if ip == nil {
+		return unknownZone
+	}
+
+	if zone := z.resolver.Zone(ip); zone != "" {
+		return zone
+	}
+
+	return unknownZone
+}
+
+// get returns the local.zone and peer.zone attributes of a connection between local and peer.
+func (z *zoneTraffic) get(local, peer net.Addr) []attribute.KeyValue {
+	return []attribute.KeyValue{localZoneKey.String(z.zone(local)), peerZoneKey.String(z.zone(peer))}
+}
+
+// record the message bytes sent and received by the rpc between the zones of attrs.
+func (z *zoneTraffic) record(ctx context.Context, ri *rpcInfo, attrs []attribute.KeyValue) {
+	set := metric.WithAttributeSet(attribute.NewSet(attrs...))
+
+	z.sent.Add(ctx, atomic.LoadInt64(&ri.sentBytes), set)
+	z.received.Add(ctx, atomic.LoadInt64(&ri.recvBytes), set)
+}